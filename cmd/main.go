@@ -2,80 +2,326 @@ package main
 
 import (
 	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/cors"
 	"api-file-upload-go/internal/database"
+	"api-file-upload-go/internal/events"
 	"api-file-upload-go/internal/handlers"
 	"api-file-upload-go/internal/logger"
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/progress"
+	"api-file-upload-go/internal/ratelimit"
+	"api-file-upload-go/internal/tracing"
+	"api-file-upload-go/internal/webhooks"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"strconv"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
+// cancelGrace is how long uploads cancelled at the shutdown deadline get to
+// clean up their partial files before connections are closed
+const cancelGrace = 5 * time.Second
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	// Load configuration
-	cfg := config.Load()
+	// config print dumps the effective configuration and exits
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	// Load configuration: defaults, then the config file, then the
+	// environment
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	flag.Parse()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize logger
-	logger := logger.New(cfg.LogLevel)
+	logger := logger.New(cfg.LogLevel, cfg.LogFormat)
+	logger.AddHook(tracing.LogHook{})
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracingExporter, cfg.TracingSampleRatio)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing:", err)
+	}
 
 	// Initialize database
 	db, err := database.Init(cfg.Database)
 	if err != nil {
 		logger.Fatal("Failed to initialize database:", err)
 	}
+	if err := tracing.InstrumentGORM(db); err != nil {
+		logger.Fatal("Failed to instrument database:", err)
+	}
 
 	// Set Gin mode based on environment
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Create Gin router
-	r := gin.New()
+	// Start delivering webhook events
+	dispatcher := webhooks.NewDispatcher(cfg, db, logger)
+	dispatcher.Start()
 
-	// Add middleware
-	r.Use(gin.Logger())
-	r.Use(gin.Recovery())
+	// Start streaming events to connected clients
+	broker := events.NewBroker(cfg, db, logger)
+	if err := broker.Start(); err != nil {
+		logger.Fatal("Failed to start event stream:", err)
+	}
 
-	// Add CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	// Track upload progress and cancel stalled or slow uploads
+	uploads := progress.NewRegistry(cfg.UploadIdleTimeout, cfg.UploadMinRate, cfg.UploadMinRateWindow)
+	uploads.Start()
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	// Expose database pool and active upload metrics
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Fatal("Failed to get database connection pool:", err)
+	}
+	if err := metrics.RegisterDB(sqlDB, "uploader"); err != nil {
+		logger.Fatal("Failed to register database metrics:", err)
+	}
+	if err := metrics.RegisterUploads(uploads); err != nil {
+		logger.Fatal("Failed to register upload metrics:", err)
+	}
 
-		c.Next()
-	})
+	// Rate limits, upload caps and bandwidth quotas
+	limiter, err := ratelimit.New(cfg, db, logger)
+	if err != nil {
+		logger.Fatal("Failed to create rate limiter:", err)
+	}
+	limiter.Start()
 
 	// Create file handler
-	fileHandler := handlers.NewFileHandler(cfg, db, logger)
+	fileHandler := handlers.NewFileHandler(cfg, db, logger, dispatcher, broker, uploads, limiter)
+
+	// Create Gin router. Client IPs are only taken from X-Forwarded-For when
+	// the request comes through one of TRUSTED_PROXIES.
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
+	// Trace requests, continuing traces from incoming traceparent headers;
+	// probes and metrics scrapes are left out
+	untraced := map[string]bool{"/health": true, "/livez": true, "/readyz": true, "/metrics": true}
+	r.Use(otelgin.Middleware("file-upload-api", otelgin.WithFilter(func(req *http.Request) bool {
+		return !untraced[req.URL.Path]
+	})))
+
+	// Request IDs and access log, then panic recovery logged the same way
+	r.Use(fileHandler.LogRequests)
+	r.Use(fileHandler.Recovery())
+	r.Use(metrics.Middleware())
+
+	// CORS, ahead of the limits so browsers can read their refusals
+	corsPolicy := cors.New(cfg)
+	r.Use(corsPolicy.Handle)
+
+	// Rate limits, counting the raw request body against the bandwidth quota
+	r.Use(fileHandler.RateLimit)
+
+	// Default timeouts and body limit, replaced by upload, download and
+	// event stream routes
+	r.Use(fileHandler.LimitRequests)
 
 	// Setup routes
 	handlers.SetupRoutes(r, fileHandler)
 
-	// Get port from config
 	port := cfg.Port
-	if port == "" {
-		logger.Fatal("PORT environment variable is required")
-	}
 
-	// Validate port
-	if _, err := strconv.Atoi(port); err != nil {
-		logger.Fatal("Invalid PORT value:", err)
+	// Start server. Read and write timeouts are set per request by
+	// LimitRequests, since uploads, downloads and event streams need more
+	// time than the rest of the API.
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
-
-	// Start server
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
 	logger.Infof("Starting File Upload API on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+
+	// Reload the upload policy, rate limits, log level and CORS on SIGHUP
+	// and when the config file changes
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	go watchConfig(reloadCtx, *configFile, cfg, logger, fileHandler, limiter, corsPolicy)
+
+	// Run until SIGINT/SIGTERM; a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
 		logger.Fatal("Failed to start server:", err)
+	case <-ctx.Done():
+	}
+	stop()
+	stopReload()
+
+	// Fail readiness and refuse new uploads, giving load balancers time to
+	// stop routing to this instance
+	logger.Infof("Shutting down, draining in-flight requests for up to %s", cfg.ShutdownTimeout)
+	fileHandler.Drain()
+	time.Sleep(cfg.ShutdownDelay)
+
+	// Event streams never end on their own
+	broker.Stop()
+
+	// Stop listening and wait for in-flight requests. Past the deadline,
+	// uploads still receiving are cancelled so they remove their partial
+	// files, then the remaining connections are closed.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("Requests still running after %s: %v", cfg.ShutdownTimeout, err)
+		if cancelled := uploads.CancelAll(); cancelled > 0 {
+			logger.Warnf("Cancelled %d uploads", cancelled)
+		}
+		graceCtx, cancelGraceCtx := context.WithTimeout(context.Background(), cancelGrace)
+		if err := uploads.Wait(graceCtx); err != nil {
+			logger.Warn("Uploads still running, closing connections:", err)
+		}
+		cancelGraceCtx()
+		srv.Close()
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Server error:", err)
+	}
+
+	// Background work and connections
+	uploads.Stop()
+	limiter.Stop()
+	dispatcher.Stop()
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Warn("Failed to flush traces:", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		logger.Warn("Failed to close database connections:", err)
+	}
+	logger.Info("Server stopped")
+}
+
+// watchConfig reloads the configuration on SIGHUP and when the config file
+// changes, until ctx is done. An invalid configuration is rejected and the
+// current one kept; otherwise every changed setting is logged and those that
+// can change while running are applied. Settings that need a restart keep
+// the value the process started with, so they are compared against it and
+// a pending value is only reported once.
+func watchConfig(ctx context.Context, file string, current *config.Config, logger *logrus.Logger, fileHandler *handlers.FileHandler, limiter *ratelimit.Limiter, corsPolicy *cors.Policy) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var fileChanged <-chan struct{}
+	if file != "" {
+		fileChanged = config.Watch(ctx, file, configPollInterval)
+	}
+
+	// Restart-only settings whose new value was already reported, by name
+	pending := map[string]string{}
+
+	for {
+		var trigger string
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			trigger = "SIGHUP"
+		case <-fileChanged:
+			trigger = "file change"
+		}
+		reloadLog := logger.WithField("trigger", trigger)
+
+		next, changes, err := config.Reload(file, current)
+		if err != nil {
+			metrics.ConfigReloaded(metrics.ReloadRejected)
+			reloadLog.Error("Configuration reload rejected, keeping the current configuration: ", err)
+			continue
+		}
+
+		if level, err := logrus.ParseLevel(next.LogLevel); err == nil {
+			logger.SetLevel(level)
+		}
+		fileHandler.SetConfig(next)
+		limiter.Configure(next)
+		corsPolicy.Configure(next)
+		current = next
+		metrics.ConfigReloaded(metrics.ReloadApplied)
+
+		changed := 0
+		stillPending := map[string]bool{}
+		for _, change := range changes {
+			changeLog := reloadLog.WithFields(logrus.Fields{"setting": change.Name, "old": change.Old, "new": change.New})
+			if !change.Restart {
+				changed++
+				changeLog.Info("Configuration setting changed")
+				continue
+			}
+			stillPending[change.Name] = true
+			if reported, ok := pending[change.Name]; ok && reported == change.New {
+				continue
+			}
+			pending[change.Name] = change.New
+			changed++
+			changeLog.Warn("Configuration setting changed, restart to apply it")
+		}
+		for name := range pending {
+			if !stillPending[name] {
+				delete(pending, name)
+				changed++
+				reloadLog.WithField("setting", name).Info("Configuration setting back to its running value, no restart needed")
+			}
+		}
+		reloadLog.Infof("Configuration reloaded, %d settings changed", changed)
+	}
+}
+
+// configCommand runs "config print [-config file] [-format yaml|env]",
+// writing the effective configuration with secrets redacted. Problems in the
+// configuration are listed after it, and make the command fail.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: api-file-upload-go config print [-config file] [-format yaml|env]")
+		return 2
+	}
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	format := flags.String("format", "yaml", "output format: yaml or env")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, loadErr := config.Load(*configFile)
+	if err := config.Print(os.Stdout, cfg, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if loadErr != nil {
+		fmt.Fprintln(os.Stderr, loadErr)
+		return 1
 	}
+	return 0
 }
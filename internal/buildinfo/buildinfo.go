@@ -0,0 +1,44 @@
+// Package buildinfo describes the running binary. Version, Commit and Date
+// can be set at build time with -ldflags "-X api-file-upload-go/internal/buildinfo.Commit=...";
+// otherwise the commit and date recorded by the Go toolchain are used.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "1.0.0"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build description reported by the health endpoint
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build description
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+	return info
+}
@@ -1,42 +1,162 @@
+// Package config loads the API configuration: defaults, then an optional
+// YAML or TOML file, then environment variables. Every setting is named by
+// its environment variable; the file uses the same names in lower case.
 package config
 
 import (
-	"os"
-	"strconv"
 	"strings"
+	"time"
 )
 
-type Config struct {
-	Database          string
-	Port              string
-	UploadDir         string
-	MaxFileSize       int64
-	AllowedExtensions []string
-	LogLevel          string
-	Environment       string
+// Rate is a request rate: Count requests per Period, in bursts of up to
+// Count. A zero Count means unlimited.
+type Rate struct {
+	Count  int
+	Period time.Duration
 }
 
-func Load() *Config {
-	maxFileSize := int64(0)
-	if maxSizeStr := os.Getenv("MAX_FILE_SIZE"); maxSizeStr != "" {
-		if parsed, err := strconv.ParseInt(maxSizeStr, 10, 64); err == nil {
-			maxFileSize = parsed
-		}
-	}
-
-	allowedExtensions := []string{}
-	if extStr := os.Getenv("ALLOWED_EXTENSIONS"); extStr != "" {
-		allowedExtensions = strings.Split(extStr, ",")
-	}
+// Config is the API configuration. The env tag names the setting; numbers
+// can't be negative, and the other tags add checks:
+//
+//   - unit:"bytes" accepts sizes such as 10MiB
+//   - positive:"true" rejects 0
+//   - max:"n" caps the value
+//   - oneof:"a,b" lists the accepted values, matched case-insensitively
+//   - required:"true" rejects an empty value
+//   - secret:"true" is redacted when the configuration is printed
+//   - reload:"true" is applied by Reload while running; other settings
+//     take effect on restart
+type Config struct {
+	Database             string                   `env:"DATABASE" required:"true" secret:"true"`
+	Port                 string                   `env:"PORT" required:"true"`
+	UploadDir            string                   `env:"UPLOAD_DIR"`
+	MaxFileSize          int64                    `env:"MAX_FILE_SIZE" unit:"bytes" reload:"true"`
+	AllowedExtensions    []string                 `env:"ALLOWED_EXTENSIONS" reload:"true"`
+	MaxFileVersions      int                      `env:"MAX_FILE_VERSIONS" reload:"true"`
+	MaxBatchSize         int                      `env:"MAX_BATCH_SIZE" positive:"true" reload:"true"`
+	MaxUploadFiles       int                      `env:"MAX_UPLOAD_FILES" positive:"true" reload:"true"`
+	MaxRequestSize       int64                    `env:"MAX_REQUEST_SIZE" unit:"bytes" reload:"true"`
+	MaxArchiveFiles      int                      `env:"MAX_ARCHIVE_FILES" positive:"true" reload:"true"`
+	MaxArchiveSize       int64                    `env:"MAX_ARCHIVE_SIZE" unit:"bytes" reload:"true"`
+	MaxExtractEntries    int                      `env:"MAX_EXTRACT_ENTRIES" positive:"true" reload:"true"`
+	MaxExtractSize       int64                    `env:"MAX_EXTRACT_SIZE" unit:"bytes" positive:"true" reload:"true"`
+	MaxExtractRatio      int                      `env:"MAX_EXTRACT_RATIO" positive:"true" reload:"true"`
+	ThumbnailDir         string                   `env:"THUMBNAIL_DIR"`
+	MaxImagePixels       int64                    `env:"MAX_IMAGE_PIXELS" positive:"true" reload:"true"`
+	MediaWorkers         int                      `env:"MEDIA_WORKERS" positive:"true"`
+	StripImageMetadata   bool                     `env:"STRIP_IMAGE_METADATA" reload:"true"`
+	KeepImageOriginals   bool                     `env:"KEEP_IMAGE_ORIGINALS" reload:"true"`
+	AdminToken           string                   `env:"ADMIN_TOKEN" secret:"true" reload:"true"`
+	WebhookTimeout       time.Duration            `env:"WEBHOOK_TIMEOUT" positive:"true"`
+	WebhookMaxAttempts   int                      `env:"WEBHOOK_MAX_ATTEMPTS" positive:"true"`
+	WebhookRetryDelay    time.Duration            `env:"WEBHOOK_RETRY_DELAY" positive:"true"`
+	WebhookWorkers       int                      `env:"WEBHOOK_WORKERS" positive:"true"`
+	WebhookAllowedNets   []string                 `env:"WEBHOOK_ALLOWED_NETWORKS"`
+	EventLogSize         int                      `env:"EVENT_LOG_SIZE" positive:"true"`
+	MaxEventStreams      int                      `env:"MAX_EVENT_STREAMS" positive:"true"`
+	UploadIdleTimeout    time.Duration            `env:"UPLOAD_IDLE_TIMEOUT"`
+	UploadMinRate        int64                    `env:"UPLOAD_MIN_RATE" unit:"bytes"`
+	UploadMinRateWindow  time.Duration            `env:"UPLOAD_MIN_RATE_WINDOW" positive:"true"`
+	ReadHeaderTimeout    time.Duration            `env:"READ_HEADER_TIMEOUT" positive:"true"`
+	IdleTimeout          time.Duration            `env:"IDLE_TIMEOUT" positive:"true"`
+	RequestTimeout       time.Duration            `env:"REQUEST_TIMEOUT" reload:"true"`
+	DownloadTimeout      time.Duration            `env:"DOWNLOAD_TIMEOUT" reload:"true"`
+	MaxBodySize          int64                    `env:"MAX_BODY_SIZE" unit:"bytes" reload:"true"`
+	TrustedProxies       []string                 `env:"TRUSTED_PROXIES"`
+	RateLimitUploads     Rate                     `env:"RATE_LIMIT_UPLOADS" reload:"true"`
+	RateLimitDownloads   Rate                     `env:"RATE_LIMIT_DOWNLOADS" reload:"true"`
+	RateLimitMetadata    Rate                     `env:"RATE_LIMIT_METADATA" reload:"true"`
+	RateLimitKey         string                   `env:"RATE_LIMIT_KEY" oneof:"auto,ip" reload:"true"`
+	RateLimitStore       string                   `env:"RATE_LIMIT_STORE" oneof:"memory,postgres"`
+	MaxConcurrentUploads int                      `env:"MAX_CONCURRENT_UPLOADS" reload:"true"`
+	DailyBandwidthQuota  int64                    `env:"DAILY_BANDWIDTH_QUOTA" unit:"bytes" reload:"true"`
+	DownloadRate         int64                    `env:"DOWNLOAD_RATE_LIMIT" unit:"bytes" reload:"true"`
+	TenantDownloadRate   int64                    `env:"TENANT_DOWNLOAD_RATE_LIMIT" unit:"bytes" reload:"true"`
+	MonthlyEgressQuota   int64                    `env:"MONTHLY_EGRESS_QUOTA" unit:"bytes" reload:"true"`
+	CORSAllowedOrigins   []string                 `env:"CORS_ALLOWED_ORIGINS" reload:"true"`
+	CORSRouteOrigins     map[string][]string      `env:"CORS_ROUTE_ORIGINS" reload:"true"`
+	CORSAllowCredentials bool                     `env:"CORS_ALLOW_CREDENTIALS" reload:"true"`
+	CORSAllowedMethods   []string                 `env:"CORS_ALLOWED_METHODS" reload:"true"`
+	CORSAllowedHeaders   []string                 `env:"CORS_ALLOWED_HEADERS" reload:"true"`
+	CORSExposedHeaders   []string                 `env:"CORS_EXPOSED_HEADERS" reload:"true"`
+	CORSMaxAge           time.Duration            `env:"CORS_MAX_AGE" reload:"true"`
+	ShutdownTimeout      time.Duration            `env:"SHUTDOWN_TIMEOUT"`
+	ShutdownDelay        time.Duration            `env:"SHUTDOWN_DELAY"`
+	HealthCacheTTL       time.Duration            `env:"HEALTH_CACHE_TTL"`
+	HealthCheckTimeout   time.Duration            `env:"HEALTH_CHECK_TIMEOUT" positive:"true"`
+	HealthCheckTimeouts  map[string]time.Duration `env:"HEALTH_CHECK_TIMEOUTS"`
+	HealthMinFreeBytes   int64                    `env:"HEALTH_MIN_FREE_BYTES" unit:"bytes"`
+	ScannerAddr          string                   `env:"SCANNER_ADDR"`
+	TracingExporter      string                   `env:"TRACING_EXPORTER" oneof:"none,stdout,otlp"`
+	TracingSampleRatio   float64                  `env:"TRACING_SAMPLE_RATIO" max:"1"`
+	LogLevel             string                   `env:"LOG_LEVEL" oneof:"debug,info,warn,error" reload:"true"`
+	LogFormat            string                   `env:"LOG_FORMAT" oneof:"text,json"`
+	LogSampleRates       map[string]float64       `env:"LOG_SAMPLE_ROUTES" reload:"true"`
+	Environment          string                   `env:"ENVIRONMENT"`
+}
 
+// Default returns the configuration used for settings that aren't set.
+// MAX_REQUEST_SIZE and THUMBNAIL_DIR default to values derived from other
+// settings, filled in by Load.
+func Default() *Config {
 	return &Config{
-		Database:          normalizeDatabaseURL(os.Getenv("DATABASE")),
-		Port:              os.Getenv("PORT"),
-		UploadDir:         os.Getenv("UPLOAD_DIR"),
-		MaxFileSize:       maxFileSize,
-		AllowedExtensions: allowedExtensions,
-		LogLevel:          os.Getenv("LOG_LEVEL"),
-		Environment:       os.Getenv("ENVIRONMENT"),
+		UploadDir:            "./uploads",
+		AllowedExtensions:    []string{},
+		MaxBatchSize:         100,
+		MaxUploadFiles:       20,
+		MaxArchiveFiles:      1000,
+		MaxArchiveSize:       2 << 30,
+		MaxExtractEntries:    1000,
+		MaxExtractSize:       1 << 30,
+		MaxExtractRatio:      100,
+		MaxImagePixels:       50000000,
+		MediaWorkers:         2,
+		WebhookTimeout:       10 * time.Second,
+		WebhookMaxAttempts:   8,
+		WebhookRetryDelay:    30 * time.Second, // doubles with every failed attempt
+		WebhookWorkers:       4,
+		WebhookAllowedNets:   []string{},
+		EventLogSize:         1000,
+		MaxEventStreams:      1000,
+		UploadIdleTimeout:    60 * time.Second,
+		UploadMinRate:        1024,
+		UploadMinRateWindow:  30 * time.Second,
+		ReadHeaderTimeout:    10 * time.Second,
+		IdleTimeout:          120 * time.Second,
+		RequestTimeout:       30 * time.Second,
+		DownloadTimeout:      time.Hour,
+		MaxBodySize:          1 << 20,
+		TrustedProxies:       []string{},
+		RateLimitUploads:     Rate{Count: 60, Period: time.Minute},
+		RateLimitDownloads:   Rate{Count: 600, Period: time.Minute},
+		RateLimitMetadata:    Rate{Count: 1200, Period: time.Minute},
+		RateLimitKey:         "ip",
+		RateLimitStore:       "memory",
+		MaxConcurrentUploads: 4,
+		CORSAllowedOrigins:   []string{"*"},
+		CORSRouteOrigins:     map[string][]string{},
+		CORSAllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		// Including the tus resumable upload headers
+		CORSAllowedHeaders: []string{
+			"Content-Type", "Authorization", "X-Request-ID", "X-API-Key", "X-User-ID", "X-Tenant-ID",
+			"If-Match", "If-None-Match", "Range", "Last-Event-ID",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length", "Upload-Concat",
+		},
+		CORSExposedHeaders: []string{
+			"Content-Disposition", "Content-Length", "Content-Range", "ETag", "Location", "X-Request-ID",
+			"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+			"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires",
+		},
+		CORSMaxAge:          10 * time.Minute,
+		ShutdownTimeout:     30 * time.Second,
+		HealthCacheTTL:      5 * time.Second,
+		HealthCheckTimeout:  2 * time.Second,
+		HealthCheckTimeouts: map[string]time.Duration{},
+		TracingExporter:     "none",
+		TracingSampleRatio:  1,
+		LogLevel:            "info",
+		LogFormat:           "text",
+		LogSampleRates:      map[string]float64{"/health": 0.01, "/livez": 0.01, "/readyz": 0.01, "/metrics": 0.01},
 	}
 }
 
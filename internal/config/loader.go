@@ -0,0 +1,397 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Error lists every problem found in the configuration
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// field is a setting of Config
+type field struct {
+	index int
+	name  string // environment variable
+	tag   reflect.StructTag
+}
+
+// fields lists the settings of Config in declaration order
+var fields = func() []field {
+	t := reflect.TypeOf(Config{})
+	list := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Tag.Get("env"); name != "" {
+			list = append(list, field{index: i, name: name, tag: t.Field(i).Tag})
+		}
+	}
+	return list
+}()
+
+// value is a raw setting and where it came from
+type value struct {
+	raw    string
+	source string
+}
+
+// Load builds the configuration from the defaults, the YAML or TOML file
+// named file (if not empty) and the environment, in increasing priority. Any
+// setting NAME can instead be read from the file named by NAME_FILE, which
+// suits secrets mounted as files.
+//
+// Every problem found is reported in a single *Error; the configuration is
+// returned along with it, so it can still be printed.
+func Load(file string) (*Config, error) {
+	var problems []string
+	values := map[string]value{}
+
+	if file != "" {
+		fileValues, err := readFile(file)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for name, v := range fileValues {
+			values[name] = v
+		}
+	}
+
+	for _, f := range fields {
+		raw, set := os.LookupEnv(f.name)
+		secretFile, fromFile := os.LookupEnv(f.name + "_FILE")
+		switch {
+		case set && fromFile:
+			problems = append(problems, fmt.Sprintf("%s and %s_FILE are both set", f.name, f.name))
+		case set:
+			values[f.name] = value{raw: raw, source: "environment"}
+		case fromFile:
+			values[f.name] = value{raw: secretFile, source: "environment (" + f.name + "_FILE)"}
+		}
+	}
+
+	cfg := Default()
+	target := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields {
+		v, ok := values[f.name]
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(v.source, "_FILE)") {
+			secret, err := readSecret(v.raw)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s_FILE: %v", f.name, err))
+				delete(values, f.name)
+				continue
+			}
+			v.raw = secret
+		}
+		set, err := setField(target.Field(f.index), f, v.raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (from %s): %v", f.name, v.source, err))
+		}
+		if !set {
+			delete(values, f.name)
+		}
+	}
+
+	// Defaults derived from other settings: room for MAX_UPLOAD_FILES
+	// full-size files plus multipart overhead, and renditions next to the
+	// uploads
+	if _, ok := values["MAX_REQUEST_SIZE"]; !ok && cfg.MaxFileSize > 0 {
+		cfg.MaxRequestSize = cfg.MaxFileSize*int64(cfg.MaxUploadFiles) + 1<<20
+	}
+	if _, ok := values["THUMBNAIL_DIR"]; !ok {
+		cfg.ThumbnailDir = filepath.Join(cfg.UploadDir, "thumbnails")
+	}
+
+	cfg.Database = normalizeDatabaseURL(cfg.Database)
+	for i, ext := range cfg.AllowedExtensions {
+		cfg.AllowedExtensions[i] = strings.ToLower(ext)
+	}
+
+	problems = append(problems, validate(cfg)...)
+	if len(problems) > 0 {
+		return cfg, &Error{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readSecret reads a setting from a file, without its trailing newline
+func readSecret(name string) (string, error) {
+	data, err := os.ReadFile(strings.TrimSpace(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readFile reads the settings of a YAML (.yaml, .yml) or TOML (.toml) file.
+// Keys are the environment variable names in either case, with the _file
+// suffix reading a setting from another file. Lists may be written as
+// sequences and maps as mappings.
+func readFile(name string) (map[string]value, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	doc := map[string]any{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format, use .yaml, .yml or .toml", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", name, err)
+	}
+
+	known := map[string]field{}
+	for _, f := range fields {
+		known[f.name] = f
+	}
+
+	values := map[string]value{}
+	var problems []string
+	for _, key := range sortedKeys(doc) {
+		setting, fromFile := strings.ToUpper(key), false
+		f, ok := known[setting]
+		if !ok {
+			if f, ok = known[strings.TrimSuffix(setting, "_FILE")]; ok {
+				fromFile = true
+			}
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", key))
+			continue
+		}
+		if _, dup := values[f.name]; dup {
+			problems = append(problems, fmt.Sprintf("%s is set more than once", f.name))
+			continue
+		}
+
+		sep := ","
+		if reflect.TypeOf(Config{}).Field(f.index).Type == reflect.TypeOf(map[string][]string{}) {
+			sep = ";"
+		}
+		v := value{raw: flatten(doc[key], sep), source: name}
+		if fromFile {
+			v.source = name + " (" + f.name + "_FILE)"
+		}
+		values[f.name] = v
+	}
+
+	if len(problems) > 0 {
+		return values, fmt.Errorf("config file %s: %s", name, strings.Join(problems, ", "))
+	}
+	return values, nil
+}
+
+// flatten formats a decoded file value the way it is written in the
+// environment: lists comma-separated and maps as name=value pairs separated
+// by sep
+func flatten(v any, sep string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = flatten(item, sep)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		return formatPairs(v, sep, func(item any) string { return flatten(item, ",") })
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// setField parses raw into a setting, reporting whether it was set. Empty
+// values leave scalar settings at their default, while they empty lists and
+// maps.
+func setField(v reflect.Value, f field, raw string) (bool, error) {
+	raw = strings.TrimSpace(raw)
+	switch target := v.Addr().Interface().(type) {
+	case *[]string:
+		*target = parseList(raw)
+		return true, nil
+	case *map[string][]string:
+		parsed, err := parseRouteOrigins(raw)
+		if err == nil {
+			*target = parsed
+		}
+		return err == nil, err
+	case *map[string]time.Duration:
+		parsed, err := parseTimeouts(raw)
+		if err == nil {
+			*target = parsed
+		}
+		return err == nil, err
+	case *map[string]float64:
+		parsed, err := parseSampleRates(raw)
+		if err == nil {
+			*target = parsed
+		}
+		return err == nil, err
+	}
+
+	if raw == "" {
+		return false, nil
+	}
+	var parsed any
+	var err error
+	switch v.Interface().(type) {
+	case string:
+		parsed = raw
+	case bool:
+		if parsed, err = strconv.ParseBool(raw); err != nil {
+			err = fmt.Errorf("invalid boolean %q (use true or false)", raw)
+		}
+	case int:
+		if parsed, err = strconv.Atoi(raw); err != nil {
+			err = fmt.Errorf("invalid integer %q", raw)
+		}
+	case int64:
+		if f.tag.Get("unit") == "bytes" {
+			parsed, err = parseSize(raw)
+		} else if parsed, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			err = fmt.Errorf("invalid integer %q", raw)
+		}
+	case float64:
+		if parsed, err = strconv.ParseFloat(raw, 64); err != nil {
+			err = fmt.Errorf("invalid number %q", raw)
+		}
+	case time.Duration:
+		parsed, err = parseDuration(raw)
+	case Rate:
+		parsed, err = parseRate(raw)
+	default:
+		err = fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	if err != nil {
+		return false, err
+	}
+	v.Set(reflect.ValueOf(parsed))
+	return true, nil
+}
+
+// validate checks cfg against the tags of its settings and the checks that
+// don't fit a tag, returning every problem found
+func validate(cfg *Config) []string {
+	var problems []string
+	target := reflect.ValueOf(cfg).Elem()
+
+	for _, f := range fields {
+		v := target.Field(f.index)
+		var number float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int64:
+			number = float64(v.Int())
+		case reflect.Float64:
+			number = v.Float()
+		case reflect.String:
+			if oneof := f.tag.Get("oneof"); oneof != "" {
+				v.SetString(strings.ToLower(v.String()))
+				if !contains(strings.Split(oneof, ","), v.String()) {
+					problems = append(problems, fmt.Sprintf("%s must be one of %s, not %q", f.name, strings.ReplaceAll(oneof, ",", ", "), v.String()))
+				}
+			}
+			if f.tag.Get("required") == "true" && v.String() == "" {
+				problems = append(problems, fmt.Sprintf("%s is required", f.name))
+			}
+			continue
+		default:
+			continue
+		}
+
+		switch {
+		case number < 0:
+			problems = append(problems, fmt.Sprintf("%s must not be negative", f.name))
+		case number == 0 && f.tag.Get("positive") == "true":
+			problems = append(problems, fmt.Sprintf("%s must be greater than 0", f.name))
+		}
+		if limit := f.tag.Get("max"); limit != "" {
+			if maximum, _ := strconv.ParseFloat(limit, 64); number > maximum {
+				problems = append(problems, fmt.Sprintf("%s must be at most %s", f.name, limit))
+			}
+		}
+	}
+
+	if cfg.Port != "" {
+		if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("PORT must be a port number between 1 and 65535, not %q", cfg.Port))
+		}
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES: %q is not an IP or CIDR", proxy))
+			}
+		}
+	}
+	for _, network := range cfg.WebhookAllowedNets {
+		if net.ParseIP(network) == nil {
+			if _, _, err := net.ParseCIDR(network); err != nil {
+				problems = append(problems, fmt.Sprintf("WEBHOOK_ALLOWED_NETWORKS: %q is not an IP or CIDR", network))
+			}
+		}
+	}
+	if cfg.ScannerAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.ScannerAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("SCANNER_ADDR must be host:port: %v", err))
+		}
+	}
+
+	origins := map[string][]string{"CORS_ALLOWED_ORIGINS": cfg.CORSAllowedOrigins}
+	for route, list := range cfg.CORSRouteOrigins {
+		origins["CORS_ROUTE_ORIGINS "+route] = list
+	}
+	for _, name := range sortedKeys(origins) {
+		for _, origin := range origins[name] {
+			if _, err := path.Match(origin, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid origin pattern %q", name, origin))
+			}
+		}
+	}
+	return problems
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
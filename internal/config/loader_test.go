@@ -0,0 +1,257 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequired sets the settings Load can't do without
+func setRequired(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE", "postgres://localhost:5432/files")
+	t.Setenv("PORT", "8080")
+}
+
+// writeFile writes content to name in a temporary directory and returns its
+// path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return file
+}
+
+// loadProblems loads file expecting it to fail and returns the problems found
+func loadProblems(t *testing.T, file string) []string {
+	t.Helper()
+	_, err := Load(file)
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected a configuration error, got %v", err)
+	}
+	return cfgErr.Problems
+}
+
+// hasProblem reports whether one of problems contains text
+func hasProblem(problems []string, text string) bool {
+	for _, problem := range problems {
+		if strings.Contains(problem, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setRequired(t)
+	t.Setenv("UPLOAD_DIR", "/srv/uploads")
+	t.Setenv("MAX_FILE_SIZE", "10MiB")
+	t.Setenv("MAX_UPLOAD_FILES", "4")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.MaxFileSize != 10<<20 {
+		t.Errorf("Expected MAX_FILE_SIZE of %d, got %d", 10<<20, cfg.MaxFileSize)
+	}
+	// Derived from the other settings when not set
+	if want := int64(4*10<<20 + 1<<20); cfg.MaxRequestSize != want {
+		t.Errorf("Expected MAX_REQUEST_SIZE of %d, got %d", want, cfg.MaxRequestSize)
+	}
+	if want := filepath.Join("/srv/uploads", "thumbnails"); cfg.ThumbnailDir != want {
+		t.Errorf("Expected THUMBNAIL_DIR %s, got %s", want, cfg.ThumbnailDir)
+	}
+	if cfg.MaxBatchSize != Default().MaxBatchSize {
+		t.Errorf("Expected the default MAX_BATCH_SIZE, got %d", cfg.MaxBatchSize)
+	}
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	setRequired(t)
+	t.Setenv("ALLOWED_EXTENSIONS", " .PDF, .txt,, ")
+	t.Setenv("RATE_LIMIT_UPLOADS", "5/s")
+	t.Setenv("UPLOAD_IDLE_TIMEOUT", "90")
+	t.Setenv("HEALTH_CHECK_TIMEOUTS", "database=2s, disk=500ms")
+	t.Setenv("STRIP_IMAGE_METADATA", "true")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{".pdf", ".txt"}; !reflect.DeepEqual(cfg.AllowedExtensions, want) {
+		t.Errorf("Expected ALLOWED_EXTENSIONS %v, got %v", want, cfg.AllowedExtensions)
+	}
+	if want := (Rate{Count: 5, Period: time.Second}); cfg.RateLimitUploads != want {
+		t.Errorf("Expected RATE_LIMIT_UPLOADS %+v, got %+v", want, cfg.RateLimitUploads)
+	}
+	if cfg.UploadIdleTimeout != 90*time.Second {
+		t.Errorf("Expected UPLOAD_IDLE_TIMEOUT of 90s, got %s", cfg.UploadIdleTimeout)
+	}
+	if want := map[string]time.Duration{"database": 2 * time.Second, "disk": 500 * time.Millisecond}; !reflect.DeepEqual(cfg.HealthCheckTimeouts, want) {
+		t.Errorf("Expected HEALTH_CHECK_TIMEOUTS %v, got %v", want, cfg.HealthCheckTimeouts)
+	}
+	if !cfg.StripImageMetadata {
+		t.Error("Expected STRIP_IMAGE_METADATA to be set")
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("DATABASE", "")
+	t.Setenv("PORT", "70000")
+	t.Setenv("MAX_FILE_SIZE", "10000000TiB")
+	t.Setenv("MAX_BATCH_SIZE", "0")
+	t.Setenv("RATE_LIMIT_UPLOADS", "fast")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy")
+
+	problems := loadProblems(t, "")
+	for _, want := range []string{
+		"DATABASE is required",
+		"PORT must be a port number",
+		"MAX_FILE_SIZE (from environment)",
+		"MAX_BATCH_SIZE must be greater than 0",
+		"RATE_LIMIT_UPLOADS (from environment)",
+		`TRUSTED_PROXIES: "proxy"`,
+	} {
+		if !hasProblem(problems, want) {
+			t.Errorf("Expected a problem with %q, got %q", want, problems)
+		}
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	setRequired(t)
+	t.Setenv("ADMIN_TOKEN_FILE", writeFile(t, "admin_token", "s3cret\n"))
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.AdminToken != "s3cret" {
+		t.Errorf("Expected ADMIN_TOKEN read from its file without the newline, got %q", cfg.AdminToken)
+	}
+
+	// The setting and its file can't both be set
+	t.Setenv("ADMIN_TOKEN", "other")
+	if problems := loadProblems(t, ""); !hasProblem(problems, "ADMIN_TOKEN and ADMIN_TOKEN_FILE are both set") {
+		t.Errorf("Expected both settings to be refused, got %q", problems)
+	}
+}
+
+func TestLoadMissingSecretFile(t *testing.T) {
+	setRequired(t)
+	t.Setenv("ADMIN_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	if problems := loadProblems(t, ""); !hasProblem(problems, "ADMIN_TOKEN_FILE:") {
+		t.Errorf("Expected the missing file to be reported, got %q", problems)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	setRequired(t)
+	token := writeFile(t, "admin_token", "from-yaml-file\n")
+	file := writeFile(t, "config.yaml", `
+port: 9090
+max_file_size: 5MiB
+allowed_extensions:
+  - .JPG
+  - .png
+rate_limit_downloads: 100/m
+cors_route_origins:
+  /api/v1/files:
+    - https://a.example.com
+    - https://b.example.com
+admin_token_file: `+token+`
+`)
+	// The environment takes priority over the file
+	t.Setenv("PORT", "8081")
+
+	cfg, err := Load(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Port != "8081" {
+		t.Errorf("Expected PORT from the environment, got %s", cfg.Port)
+	}
+	if cfg.MaxFileSize != 5<<20 {
+		t.Errorf("Expected MAX_FILE_SIZE of %d, got %d", 5<<20, cfg.MaxFileSize)
+	}
+	if want := []string{".jpg", ".png"}; !reflect.DeepEqual(cfg.AllowedExtensions, want) {
+		t.Errorf("Expected ALLOWED_EXTENSIONS %v, got %v", want, cfg.AllowedExtensions)
+	}
+	if want := (Rate{Count: 100, Period: time.Minute}); cfg.RateLimitDownloads != want {
+		t.Errorf("Expected RATE_LIMIT_DOWNLOADS %+v, got %+v", want, cfg.RateLimitDownloads)
+	}
+	if want := map[string][]string{"/api/v1/files": {"https://a.example.com", "https://b.example.com"}}; !reflect.DeepEqual(cfg.CORSRouteOrigins, want) {
+		t.Errorf("Expected CORS_ROUTE_ORIGINS %v, got %v", want, cfg.CORSRouteOrigins)
+	}
+	if cfg.AdminToken != "from-yaml-file" {
+		t.Errorf("Expected ADMIN_TOKEN from admin_token_file, got %q", cfg.AdminToken)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	setRequired(t)
+	file := writeFile(t, "config.toml", `
+MAX_UPLOAD_FILES = 3
+MAX_FILE_SIZE = "1MB"
+UPLOAD_MIN_RATE_WINDOW = "1m"
+ALLOWED_EXTENSIONS = [".csv"]
+
+[HEALTH_CHECK_TIMEOUTS]
+database = "3s"
+`)
+
+	cfg, err := Load(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.MaxUploadFiles != 3 {
+		t.Errorf("Expected MAX_UPLOAD_FILES of 3, got %d", cfg.MaxUploadFiles)
+	}
+	if cfg.MaxFileSize != 1000*1000 {
+		t.Errorf("Expected MAX_FILE_SIZE of 1000000, got %d", cfg.MaxFileSize)
+	}
+	if cfg.UploadMinRateWindow != time.Minute {
+		t.Errorf("Expected UPLOAD_MIN_RATE_WINDOW of 1m, got %s", cfg.UploadMinRateWindow)
+	}
+	if want := []string{".csv"}; !reflect.DeepEqual(cfg.AllowedExtensions, want) {
+		t.Errorf("Expected ALLOWED_EXTENSIONS %v, got %v", want, cfg.AllowedExtensions)
+	}
+	if want := map[string]time.Duration{"database": 3 * time.Second}; !reflect.DeepEqual(cfg.HealthCheckTimeouts, want) {
+		t.Errorf("Expected HEALTH_CHECK_TIMEOUTS %v, got %v", want, cfg.HealthCheckTimeouts)
+	}
+}
+
+func TestLoadFileProblems(t *testing.T) {
+	setRequired(t)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"config.yaml", "max_file_size: 1MB\nmax_file_sise: 2MB\n", `unknown setting "max_file_sise"`},
+		{"config.yaml", "port: 8080\nPORT: 8081\n", "PORT is set more than once"},
+		{"config.yaml", "port: [unclosed\n", "config.yaml"},
+		{"config.toml", "MAX_BATCH_SIZE = \"many\"\n", "MAX_BATCH_SIZE (from "},
+		{"config.json", "{}", "unknown format"},
+	}
+	for _, tt := range tests {
+		problems := loadProblems(t, writeFile(t, tt.name, tt.content))
+		if !hasProblem(problems, tt.want) {
+			t.Errorf("%s %q: expected a problem with %q, got %q", tt.name, tt.content, tt.want, problems)
+		}
+	}
+
+	if problems := loadProblems(t, filepath.Join(t.TempDir(), "missing.yaml")); !hasProblem(problems, "config file:") {
+		t.Errorf("Expected the missing file to be reported, got %q", problems)
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "[redacted]"
+
+// Print writes cfg as a YAML configuration file ("yaml") or as environment
+// variables ("env"), with secrets redacted. The output can be loaded back,
+// once the secrets are filled in.
+func Print(w io.Writer, cfg *Config, output string) error {
+	switch output {
+	case "yaml":
+		doc := &yaml.Node{Kind: yaml.MappingNode}
+		each(cfg, func(name string, v any, secret bool) {
+			doc.Content = append(doc.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(name)},
+				yamlNode(v, secret))
+		})
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "env":
+		var err error
+		each(cfg, func(name string, v any, secret bool) {
+			if err == nil {
+				_, err = fmt.Fprintf(w, "%s=%s\n", name, quoteEnv(format(v, secret)))
+			}
+		})
+		return err
+	default:
+		return fmt.Errorf("unknown format %q, use yaml or env", output)
+	}
+}
+
+// each calls fn with every setting of cfg in declaration order, sizes as
+// sizeValue, and whether the setting is a secret
+func each(cfg *Config, fn func(name string, v any, secret bool)) {
+	target := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields {
+		fn(f.name, settingValue(target, f), f.tag.Get("secret") == "true")
+	}
+}
+
+// settingValue returns setting f of a Config value, sizes as sizeValue
+func settingValue(cfg reflect.Value, f field) any {
+	v := cfg.Field(f.index).Interface()
+	if f.tag.Get("unit") == "bytes" {
+		v = sizeValue(v.(int64))
+	}
+	return v
+}
+
+// sizeValue is a setting in bytes, formatted with a unit
+type sizeValue int64
+
+// format formats a setting value as it is written in the environment, with
+// secrets redacted
+func format(v any, secret bool) string {
+	if secret {
+		return redact(v.(string))
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case sizeValue:
+		return formatSize(int64(v))
+	case time.Duration:
+		return formatDuration(v)
+	case Rate:
+		return formatRate(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	case map[string][]string:
+		return formatPairs(v, ";", func(origins []string) string { return strings.Join(origins, ",") })
+	case map[string]time.Duration:
+		return formatPairs(v, ",", formatDuration)
+	case map[string]float64:
+		return formatPairs(v, ",", func(rate float64) string { return strconv.FormatFloat(rate, 'f', -1, 64) })
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// redact hides a secret; URLs keep everything but the password
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+	return redacted
+}
+
+// yamlNode encodes a setting value, lists as sequences and maps as mappings
+func yamlNode(v any, secret bool) *yaml.Node {
+	str := func(s string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	}
+	list := func(items []string) *yaml.Node {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range items {
+			node.Content = append(node.Content, str(item))
+		}
+		return node
+	}
+	mapping := func(names []string, value func(name string) *yaml.Node) *yaml.Node {
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, name := range names {
+			node.Content = append(node.Content, str(name), value(name))
+		}
+		return node
+	}
+
+	switch v := v.(type) {
+	case string:
+		return str(format(v, secret))
+	case []string:
+		return list(v)
+	case map[string][]string:
+		return mapping(sortedKeys(v), func(name string) *yaml.Node { return list(v[name]) })
+	case map[string]time.Duration:
+		return mapping(sortedKeys(v), func(name string) *yaml.Node { return str(formatDuration(v[name])) })
+	case map[string]float64:
+		return mapping(sortedKeys(v), func(name string) *yaml.Node {
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: format(v[name], false)}
+		})
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: format(v, secret)}
+	}
+}
+
+// quoteEnv quotes values that a .env file would otherwise misread
+func quoteEnv(value string) string {
+	if strings.ContainsAny(value, " \t\"'#$\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Change is a setting that differs between two configurations, with its
+// values formatted as in the environment and secrets redacted
+type Change struct {
+	Name    string
+	Old     string
+	New     string
+	Restart bool // only takes effect on restart
+}
+
+// Diff lists the settings that differ from one configuration to the other,
+// in declaration order
+func Diff(from, to *Config) []Change {
+	var changes []Change
+	fromValue, toValue := reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem()
+	for _, f := range fields {
+		if reflect.DeepEqual(fromValue.Field(f.index).Interface(), toValue.Field(f.index).Interface()) {
+			continue
+		}
+		secret := f.tag.Get("secret") == "true"
+		changes = append(changes, Change{
+			Name:    f.name,
+			Old:     format(settingValue(fromValue, f), secret),
+			New:     format(settingValue(toValue, f), secret),
+			Restart: f.tag.Get("reload") != "true",
+		})
+	}
+	return changes
+}
+
+// Reload loads the configuration again, as Load does, for the running
+// server. Settings that can't change while running keep their value from
+// current, and are reported as changes with Restart set. An invalid
+// configuration is returned as an error, and current stays in use.
+func Reload(file string, current *Config) (*Config, []Change, error) {
+	next, err := Load(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := Diff(current, next)
+	currentValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	for _, f := range fields {
+		if f.tag.Get("reload") != "true" {
+			nextValue.Field(f.index).Set(currentValue.Field(f.index))
+		}
+	}
+	return next, changes, nil
+}
+
+// Watch reports on the returned channel when file changes, checking its
+// size and modification time every interval until ctx is done. A file
+// briefly missing while it is replaced isn't a change by itself.
+func Watch(ctx context.Context, file string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	stamp := func() (time.Time, int64, bool) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, 0, false
+		}
+		return info.ModTime(), info.Size(), true
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		modTime, size, _ := stamp()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			newModTime, newSize, ok := stamp()
+			if !ok || (newModTime.Equal(modTime) && newSize == size) {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			select {
+			case changed <- struct{}{}:
+			default: // a reload is already pending
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the units accepted after a size, in lower case
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"kb": 1000, "mb": 1000 * 1000, "gb": 1000 * 1000 * 1000, "tb": 1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// parseSize parses a size in bytes: a plain number or a number followed by
+// B, KB, MB, GB, TB (powers of 1000) or KiB, MiB, GiB, TiB (powers of 1024)
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+	number, unit := value[:i], strings.ToLower(strings.TrimSpace(value[i:]))
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q (use B, KB, MB, GB, TB, KiB, MiB, GiB or TiB)", value[i:])
+	}
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+			return 0, fmt.Errorf("size %q is out of range", value)
+		}
+		return n * multiplier, nil
+	} else if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("size %q is out of range", value)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, itself out of range
+	bytes := n * float64(multiplier)
+	if bytes >= float64(math.MaxInt64) || bytes < float64(math.MinInt64) {
+		return 0, fmt.Errorf("size %q is out of range", value)
+	}
+	return int64(bytes), nil
+}
+
+// formatSize formats bytes in the unit giving the shortest exact value,
+// binary units first, so 10485760 is 10MiB and 1500000000 is 1500MB
+func formatSize(bytes int64) string {
+	best := strconv.FormatInt(bytes, 10)
+	for _, unit := range []string{"TiB", "GiB", "MiB", "KiB", "TB", "GB", "MB", "KB"} {
+		multiplier := sizeUnits[strings.ToLower(unit)]
+		if bytes == 0 || bytes%multiplier != 0 {
+			continue
+		}
+		if formatted := strconv.FormatInt(bytes/multiplier, 10) + unit; len(formatted) < len(best) {
+			best = formatted
+		}
+	}
+	return best
+}
+
+// parseDuration parses a duration such as 1m30s, or a plain number of
+// seconds
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (use seconds or a duration such as 1m30s)", value)
+	}
+	return d, nil
+}
+
+// formatDuration formats d without zero trailing units, so 1h rather than
+// 1h0m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// ratePeriods are the periods of a rate
+var ratePeriods = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+
+// parseRate parses a count/period rate such as "60/m"; periods are s, m, h
+// or d, and "0" means unlimited
+func parseRate(value string) (Rate, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" {
+		return Rate{}, nil
+	}
+	countStr, periodStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q (use count/period, such as 60/m)", value)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || count <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate count %q", countStr)
+	}
+	period, ok := ratePeriods[strings.ToLower(strings.TrimSpace(periodStr))]
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate period %q (use s, m, h or d)", periodStr)
+	}
+	return Rate{Count: count, Period: period}, nil
+}
+
+// formatRate formats a rate as parseRate reads it
+func formatRate(rate Rate) string {
+	if rate.Count <= 0 {
+		return "0"
+	}
+	for unit, period := range ratePeriods {
+		if period == rate.Period {
+			return fmt.Sprintf("%d/%s", rate.Count, unit)
+		}
+	}
+	return fmt.Sprintf("%d/%s", rate.Count, formatDuration(rate.Period))
+}
+
+// parseList parses a comma-separated list, trimming items and skipping empty
+// ones
+func parseList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePairs parses sep-separated name=value pairs with parse, skipping empty
+// entries
+func parsePairs[T any](value, sep string, parse func(name, value string) (T, error)) (map[string]T, error) {
+	pairs := map[string]T{}
+	for _, pair := range strings.Split(value, sep) {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, valueStr, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid pair %q (use name=value)", pair)
+		}
+		parsed, err := parse(name, strings.TrimSpace(valueStr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		pairs[name] = parsed
+	}
+	return pairs, nil
+}
+
+// parseRouteOrigins parses semicolon-separated route=origins pairs, the
+// origins being comma-separated
+func parseRouteOrigins(value string) (map[string][]string, error) {
+	return parsePairs(value, ";", func(route, origins string) ([]string, error) {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("route must start with /")
+		}
+		return parseList(origins), nil
+	})
+}
+
+// parseTimeouts parses comma-separated name=duration pairs
+func parseTimeouts(value string) (map[string]time.Duration, error) {
+	return parsePairs(value, ",", func(_, timeout string) (time.Duration, error) {
+		d, err := parseDuration(timeout)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("timeout must be positive")
+		}
+		return d, err
+	})
+}
+
+// parseSampleRates parses comma-separated route=rate pairs, rates being
+// between 0 and 1
+func parseSampleRates(value string) (map[string]float64, error) {
+	return parsePairs(value, ",", func(_, rateStr string) (float64, error) {
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return 0, fmt.Errorf("invalid rate %q (use a number between 0 and 1)", rateStr)
+		}
+		return rate, nil
+	})
+}
+
+// formatPairs formats a map as sep-separated name=value pairs, sorted by name
+func formatPairs[T any](pairs map[string]T, sep string, format func(T) string) string {
+	names := sortedKeys(pairs)
+	items := make([]string, len(names))
+	for i, name := range names {
+		items[i] = name + "=" + format(pairs[name])
+	}
+	return strings.Join(items, sep)
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"1048576", 1 << 20},
+		{" 512 ", 512},
+		{"100B", 100},
+		{"10KB", 10 * 1000},
+		{"10kb", 10 * 1000},
+		{"5 MB", 5 * 1000 * 1000},
+		{"2GB", 2 * 1000 * 1000 * 1000},
+		{"1TB", 1000 * 1000 * 1000 * 1000},
+		{"10KiB", 10 << 10},
+		{"10MiB", 10 << 20},
+		{"1.5GiB", 3 << 29},
+		{"2TiB", 2 << 40},
+		{"8388607TiB", 8388607 << 40},
+		{"-1", -1},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if err != nil {
+			t.Errorf("parseSize(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, expected %d", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{
+		"",
+		"MB",
+		"10XB",
+		"ten",
+		"1.2.3MB",
+		"10000000TiB",
+		"8388608TiB",
+		"-10000000TiB",
+		"9223372036854775808",
+		"9.3e18",
+		"9300000000000000000.5",
+		"8388608.5TiB",
+	} {
+		if got, err := parseSize(value); err == nil {
+			t.Errorf("parseSize(%q) = %d, expected an error", value, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0"},
+		{1000, "1KB"},
+		{1024, "1024"},
+		{1 << 20, "1MiB"},
+		{10 << 20, "10MiB"},
+		{1500 * 1000 * 1000, "1500MB"},
+		{1 << 40, "1TiB"},
+		{1234, "1234"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.bytes); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, expected %q", tt.bytes, got, tt.want)
+		}
+		if parsed, err := parseSize(formatSize(tt.bytes)); err != nil || parsed != tt.bytes {
+			t.Errorf("parseSize(formatSize(%d)) = %d, %v", tt.bytes, parsed, err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"0", 0},
+		{"30", 30 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{" 60 ", time.Minute},
+		{"1m30s", 90 * time.Second},
+		{"2h", 2 * time.Hour},
+		{"250ms", 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if err != nil {
+			t.Errorf("parseDuration(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, expected %s", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "1 minute", "10x"} {
+		if got, err := parseDuration(value); err == nil {
+			t.Errorf("parseDuration(%q) = %s, expected an error", value, got)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Rate
+	}{
+		{"0", Rate{}},
+		{"60/m", Rate{Count: 60, Period: time.Minute}},
+		{"10/s", Rate{Count: 10, Period: time.Second}},
+		{" 1000 / H ", Rate{Count: 1000, Period: time.Hour}},
+		{"5/d", Rate{Count: 5, Period: 24 * time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.value)
+		if err != nil {
+			t.Errorf("parseRate(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRate(%q) = %+v, expected %+v", tt.value, got, tt.want)
+		}
+		if parsed, err := parseRate(formatRate(got)); err != nil || parsed != got {
+			t.Errorf("parseRate(formatRate(%+v)) = %+v, %v", got, parsed, err)
+		}
+	}
+
+	for _, value := range []string{"", "60", "60/w", "0/m", "-1/s", "many/m", "/m"} {
+		if got, err := parseRate(value); err == nil {
+			t.Errorf("parseRate(%q) = %+v, expected an error", value, got)
+		}
+	}
+}
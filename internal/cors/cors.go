@@ -0,0 +1,218 @@
+// Package cors applies the cross-origin resource sharing policy set with the
+// CORS_* variables: it answers preflight requests and adds the CORS headers
+// to actual requests from allowed origins.
+package cors
+
+import (
+	"api-file-upload-go/internal/config"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// origins is a set of allowed origins
+type origins struct {
+	any      bool // "*": any origin, without credentials
+	exact    map[string]bool
+	patterns []string // path.Match patterns, such as https://*.example.com
+}
+
+func newOrigins(list []string) origins {
+	o := origins{exact: map[string]bool{}}
+	for _, origin := range list {
+		switch {
+		case origin == "*":
+			o.any = true
+		case strings.Contains(origin, "*"):
+			o.patterns = append(o.patterns, strings.ToLower(origin))
+		default:
+			o.exact[strings.ToLower(origin)] = true
+		}
+	}
+	return o
+}
+
+// match reports whether origin is allowed, and whether only by "*"
+func (o origins) match(origin string) (allowed, wildcard bool) {
+	origin = strings.ToLower(origin)
+	if o.exact[origin] {
+		return true, false
+	}
+	for _, pattern := range o.patterns {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true, false
+		}
+	}
+	return o.any, o.any
+}
+
+// route is a per-route override of the allowed origins
+type route struct {
+	template string
+	segments []string
+	origins  origins
+}
+
+// matches reports whether path matches the route template: ":name" matches
+// one segment and a trailing "*name" everything below
+func (r route) matches(p string) bool {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(parts) || (!strings.HasPrefix(segment, ":") && segment != parts[i]) {
+			return false
+		}
+	}
+	return len(parts) == len(r.segments)
+}
+
+// rules are the settings of a policy
+type rules struct {
+	origins     origins
+	routes      []route
+	credentials bool
+	methods     string
+	headers     string
+	anyHeader   bool
+	exposed     string
+	maxAge      string
+}
+
+// Policy is a CORS policy
+type Policy struct {
+	rules atomic.Pointer[rules]
+}
+
+// New creates the policy configured in cfg
+func New(cfg *config.Config) *Policy {
+	p := &Policy{}
+	p.Configure(cfg)
+	return p
+}
+
+// Configure replaces the policy with the one configured in cfg, for
+// requests from now on
+func (p *Policy) Configure(cfg *config.Config) {
+	r := &rules{
+		origins:     newOrigins(cfg.CORSAllowedOrigins),
+		credentials: cfg.CORSAllowCredentials,
+		methods:     strings.ToUpper(strings.Join(cfg.CORSAllowedMethods, ", ")),
+		headers:     strings.Join(cfg.CORSAllowedHeaders, ", "),
+		exposed:     strings.Join(cfg.CORSExposedHeaders, ", "),
+	}
+	for _, header := range cfg.CORSAllowedHeaders {
+		if header == "*" {
+			r.anyHeader = true
+		}
+	}
+	if cfg.CORSMaxAge > 0 {
+		r.maxAge = strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+	}
+
+	for template, list := range cfg.CORSRouteOrigins {
+		r.routes = append(r.routes, route{
+			template: template,
+			segments: strings.Split(strings.Trim(template, "/"), "/"),
+			origins:  newOrigins(list),
+		})
+	}
+	// The most specific template wins when several match
+	sort.Slice(r.routes, func(i, j int) bool {
+		if len(r.routes[i].segments) != len(r.routes[j].segments) {
+			return len(r.routes[i].segments) > len(r.routes[j].segments)
+		}
+		return r.routes[i].template < r.routes[j].template
+	})
+	p.rules.Store(r)
+}
+
+// originsFor returns the origins allowed on a request path. The path is
+// matched rather than the route, since preflight requests match no route.
+func (r *rules) originsFor(requestPath string) origins {
+	for _, override := range r.routes {
+		if override.matches(requestPath) {
+			return override.origins
+		}
+	}
+	return r.origins
+}
+
+// Handle is the middleware applying the policy. Preflight requests are
+// answered with 204, or 403 when the origin isn't allowed; other OPTIONS
+// requests get 204 as well. Requests from origins that aren't allowed are
+// served without CORS headers, so the browser keeps the response from the
+// calling page.
+func (p *Policy) Handle(c *gin.Context) {
+	r := p.rules.Load()
+	header := c.Writer.Header()
+	origin := c.GetHeader("Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	allowed := r.originsFor(c.Request.URL.Path)
+
+	// Responses differ per origin unless every origin gets "*"
+	if len(allowed.exact) > 0 || len(allowed.patterns) > 0 || !allowed.any {
+		header.Add("Vary", "Origin")
+	}
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	ok, wildcard := false, false
+	if origin != "" {
+		ok, wildcard = allowed.match(origin)
+	}
+	if !ok {
+		if preflight && origin != "" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+		return
+	}
+
+	if wildcard {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		if r.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if preflight {
+		header.Set("Access-Control-Allow-Methods", r.methods)
+		headers := r.headers
+		if r.anyHeader {
+			headers = c.GetHeader("Access-Control-Request-Headers")
+		}
+		if headers != "" {
+			header.Set("Access-Control-Allow-Headers", headers)
+		}
+		if r.maxAge != "" {
+			header.Set("Access-Control-Max-Age", r.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	if r.exposed != "" {
+		header.Set("Access-Control-Expose-Headers", r.exposed)
+	}
+	if c.Request.Method == http.MethodOptions {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	c.Next()
+}
@@ -8,8 +8,54 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// searchMigrations add the weighted tsvector used by the search endpoint:
+// names rank above tags, tags above custom metadata and metadata above
+// extracted text. Name separators are turned into spaces so that
+// "annual_report-2024.pdf" matches "report".
+var searchMigrations = []string{
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', regexp_replace(coalesce(original_name, ''), '[._-]+', ' ', 'g')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(tags, '')), 'B') ||
+		setweight(to_tsvector('simple', coalesce(metadata, '')), 'C') ||
+		setweight(to_tsvector('simple', coalesce(content_text, '')), 'D')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_files_search_vector ON files USING GIN (search_vector)`,
+}
+
+// Unique indexes on the names of live items, one per table; the root is
+// folder 0. The handlers check names first, and these catch the concurrent
+// requests that get past the check together.
+const (
+	FolderNameIndex = "idx_folders_live_name"
+	FileNameIndex   = "idx_files_live_name"
+)
+
+// nameMigrations add the unique name indexes
+var nameMigrations = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS ` + FolderNameIndex + ` ON folders (COALESCE(parent_id, 0), name) WHERE deleted_at IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS ` + FileNameIndex + ` ON files (COALESCE(folder_id, 0), original_name) WHERE deleted_at IS NULL`,
+}
+
+// FileHashIndex keeps the content of live files unique. Files in the trash
+// don't hold on to their hash, so deleted content can be uploaded again.
+const FileHashIndex = "idx_files_live_hash"
+
+// hashMigrations replace the unique index on every row's hash, created by
+// earlier versions, with one on live files only
+var hashMigrations = []string{
+	`DROP INDEX IF EXISTS idx_files_hash`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS ` + FileHashIndex + ` ON files (hash) WHERE deleted_at IS NULL`,
+}
+
+// migratedModels are the models whose tables AutoMigrate maintains
+var migratedModels = []interface{}{
+	&models.File{}, &models.Folder{}, &models.FileVersion{}, &models.AuditLog{},
+	&models.Webhook{}, &models.WebhookEvent{}, &models.WebhookDelivery{},
+	&models.RateLimitBucket{}, &models.BandwidthUsage{}, &models.EgressUsage{},
+}
+
 func Init(databaseURL string) (*gorm.DB, error) {
-	// Note: Database URL is already normalized by config.Load() (see config/config.go)
+	// Note: Database URL is already normalized by config.Load (see config/loader.go)
 	
 	db, err := gorm.Open(postgres.Open(databaseURL), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Error), // Only log errors
@@ -19,9 +65,59 @@ func Init(databaseURL string) (*gorm.DB, error) {
 	}
 
 	// Auto migrate
-	if err := db.AutoMigrate(&models.File{}); err != nil {
+	if err := db.AutoMigrate(migratedModels...); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Fails while a folder still holds duplicate names, from before names
+	// had to be unique; rename or delete them and start again
+	for _, statement := range nameMigrations {
+		if err := db.Exec(statement).Error; err != nil {
+			return nil, fmt.Errorf("failed to create unique name index (rename duplicate files or folders first): %w", err)
+		}
+	}
+
+	for _, statement := range hashMigrations {
+		if err := db.Exec(statement).Error; err != nil {
+			return nil, fmt.Errorf("failed to migrate content hash index: %w", err)
+		}
+	}
+
+	// Full-text search vector, maintained by Postgres itself
+	for _, statement := range searchMigrations {
+		if err := db.Exec(statement).Error; err != nil {
+			return nil, fmt.Errorf("failed to migrate search index: %w", err)
+		}
+	}
+
 	return db, nil
 }
+
+// PendingMigrations lists the tables, name and hash indexes and the search
+// column that Init creates but are missing from the database
+func PendingMigrations(db *gorm.DB) ([]string, error) {
+	var pending []string
+	migrator := db.Migrator()
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if !migrator.HasTable(stmt.Schema.Table) {
+			pending = append(pending, stmt.Schema.Table)
+		}
+	}
+	if !migrator.HasColumn(&models.File{}, "search_vector") {
+		pending = append(pending, "files.search_vector")
+	}
+	if !migrator.HasIndex(&models.Folder{}, FolderNameIndex) {
+		pending = append(pending, "folders."+FolderNameIndex)
+	}
+	if !migrator.HasIndex(&models.File{}, FileNameIndex) {
+		pending = append(pending, "files."+FileNameIndex)
+	}
+	if !migrator.HasIndex(&models.File{}, FileHashIndex) {
+		pending = append(pending, "files."+FileHashIndex)
+	}
+	return pending, nil
+}
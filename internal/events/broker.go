@@ -0,0 +1,343 @@
+// Package events streams file lifecycle events to connected clients. Events
+// come from the webhook outbox table: Postgres NOTIFY (sent when an outbox
+// transaction commits) and a periodic poll tell each replica to load new
+// rows, which are kept in a bounded in-memory log and fanned out to the
+// matching subscribers.
+package events
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Channel is the Postgres NOTIFY channel signalled when events are committed
+const Channel = "file_events"
+
+const (
+	// pollInterval is how often the outbox is checked without notifications
+	pollInterval = 2 * time.Second
+	// lookback is how far below the last seen ID each load starts. IDs are
+	// assigned before commit, so a transaction can commit after one with a
+	// higher ID; already seen events are skipped.
+	lookback = 100
+	// loadBatch bounds the rows read per query
+	loadBatch = 500
+	// subscriberBuffer is the number of events a slow client can fall
+	// behind before it is disconnected (it can resume with Last-Event-ID)
+	subscriberBuffer = 256
+	// maxListenRetry caps the delay between LISTEN reconnection attempts
+	maxListenRetry = 30 * time.Second
+)
+
+var (
+	// ErrTooManySubscribers is returned by Subscribe when the stream limit is reached
+	ErrTooManySubscribers = errors.New("too many event streams")
+	// ErrStopped is returned by Subscribe once the broker has stopped
+	ErrStopped = errors.New("event broker stopped")
+)
+
+// Event is a file lifecycle event as sent to clients
+type Event struct {
+	ID        uint                   `json:"id"`
+	Type      string                 `json:"type"`
+	TenantID  string                 `json:"tenant_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Filter selects the events a subscriber receives. An empty Types matches
+// every type.
+type Filter struct {
+	TenantID string
+	Types    map[string]bool
+}
+
+// Matches reports whether event passes the filter
+func (f Filter) Matches(event *Event) bool {
+	return event.TenantID == f.TenantID && (len(f.Types) == 0 || f.Types[event.Type])
+}
+
+// Subscription receives the live events matching its filter on C. C is
+// closed when the subscriber falls too far behind or the broker stops.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+}
+
+// Broker loads committed events and fans them out to subscribers
+type Broker struct {
+	db             *gorm.DB
+	dsn            string
+	logger         *logrus.Logger
+	logSize        int
+	maxSubscribers int
+
+	mu          sync.Mutex
+	log         []Event // most recent events, oldest first
+	seen        map[uint]bool
+	lastID      uint
+	subscribers map[*Subscription]struct{}
+	stopped     bool
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBroker creates a broker; call Start to begin loading events
+func NewBroker(cfg *config.Config, db *gorm.DB, logger *logrus.Logger) *Broker {
+	return &Broker{
+		db:             db,
+		dsn:            cfg.Database,
+		logger:         logger,
+		logSize:        max(cfg.EventLogSize, 2*lookback),
+		maxSubscribers: cfg.MaxEventStreams,
+		seen:           map[uint]bool{},
+		subscribers:    map[*Subscription]struct{}{},
+		wake:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// Start fills the event log with the most recent events, so clients can
+// resume across restarts, and starts following new ones
+func (b *Broker) Start() error {
+	var recent []models.WebhookEvent
+	if err := b.db.Order("id DESC").Limit(b.logSize).Find(&recent).Error; err != nil {
+		return err
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		b.record(newEvent(&recent[i]))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	if b.db.Dialector.Name() == "postgres" {
+		go b.listen(ctx)
+	}
+	go b.run(ctx)
+	return nil
+}
+
+// Stop stops following events and closes every subscription
+func (b *Broker) Stop() {
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	<-b.done
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for sub := range b.subscribers {
+		close(sub.ch)
+		delete(b.subscribers, sub)
+	}
+}
+
+// Wake makes the broker load new events now instead of at the next poll
+func (b *Broker) Wake() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Subscribe registers a subscriber. With lastEventID set, it also returns
+// the logged events after that one; complete is false when lastEventID is
+// older than the log, in which case every logged event is returned and the
+// client should refetch its state.
+func (b *Broker) Subscribe(filter Filter, lastEventID uint) (sub *Subscription, backlog []Event, complete bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return nil, nil, false, ErrStopped
+	}
+	if len(b.subscribers) >= b.maxSubscribers {
+		return nil, nil, false, ErrTooManySubscribers
+	}
+
+	complete = true
+	if lastEventID > 0 {
+		start := -1
+		for i := range b.log {
+			if b.log[i].ID == lastEventID {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			// Not logged: either evicted already, or not seen by this
+			// replica yet and anything after it is still to come
+			start = len(b.log)
+			if len(b.log) > 0 && lastEventID < b.log[0].ID {
+				complete = false
+				start = 0
+			}
+		}
+		for i := start; i < len(b.log); i++ {
+			if filter.Matches(&b.log[i]) {
+				backlog = append(backlog, b.log[i])
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: ch, ch: ch, filter: filter}
+	b.subscribers[sub] = struct{}{}
+	return sub, backlog, complete, nil
+}
+
+// Unsubscribe removes a subscriber
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		close(sub.ch)
+		delete(b.subscribers, sub)
+	}
+}
+
+func (b *Broker) run(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.load(); err != nil {
+			b.logger.Warn("Failed to load events:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+	}
+}
+
+// load publishes the outbox events not seen yet
+func (b *Broker) load() error {
+	for {
+		b.mu.Lock()
+		from := uint(0)
+		if b.lastID > lookback {
+			from = b.lastID - lookback
+		}
+		b.mu.Unlock()
+
+		var rows []models.WebhookEvent
+		if err := b.db.Where("id > ?", from).Order("id").Limit(loadBatch).Find(&rows).Error; err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		for i := range rows {
+			if !b.seen[rows[i].ID] {
+				b.publish(newEvent(&rows[i]))
+			}
+		}
+		b.mu.Unlock()
+
+		if len(rows) < loadBatch {
+			return nil
+		}
+	}
+}
+
+// record appends event to the log, evicting the oldest entry when full.
+// Callers hold b.mu (or run before the broker starts).
+func (b *Broker) record(event Event) {
+	if len(b.log) >= b.logSize {
+		delete(b.seen, b.log[0].ID)
+		b.log = append(b.log[:0], b.log[1:]...)
+	}
+	b.log = append(b.log, event)
+	b.seen[event.ID] = true
+	if event.ID > b.lastID {
+		b.lastID = event.ID
+	}
+}
+
+// publish records event and sends it to the matching subscribers. A
+// subscriber whose buffer is full is dropped rather than blocking everyone
+// else. Callers hold b.mu.
+func (b *Broker) publish(event Event) {
+	b.record(event)
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(&event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			close(sub.ch)
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// listen wakes the broker on every notification of Channel, reconnecting
+// with a growing delay when the connection drops. Polling keeps events
+// flowing meanwhile.
+func (b *Broker) listen(ctx context.Context) {
+	retry := time.Second
+	for {
+		err := b.waitForNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Warnf("Event listener disconnected, retrying in %s: %v", retry, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, maxListenRetry)
+	}
+}
+
+func (b *Broker) waitForNotifications(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+	// Catch up on anything committed while disconnected
+	b.Wake()
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		b.Wake()
+	}
+}
+
+func newEvent(row *models.WebhookEvent) Event {
+	return Event{
+		ID:        row.ID,
+		Type:      row.Type,
+		TenantID:  row.TenantID,
+		CreatedAt: row.CreatedAt,
+		Data:      row.Data,
+	}
+}
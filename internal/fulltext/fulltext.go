@@ -0,0 +1,217 @@
+// Package fulltext extracts searchable plain text from uploaded documents
+package fulltext
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+// MaxTextSize bounds the text kept per file. It keeps the Postgres tsvector
+// built from it well under its 1MB limit.
+const MaxTextSize = 512 << 10
+
+// maxDocumentXMLSize bounds the decompressed document.xml read from a DOCX
+const maxDocumentXMLSize = 64 << 20
+
+// ErrNotFound is returned when a DOCX has no document body
+var ErrNotFound = errors.New("document text not found")
+
+// extractors maps file extensions to the function reading their text
+var extractors = map[string]func(path string) (string, error){
+	".txt":      extractPlain,
+	".text":     extractPlain,
+	".md":       extractPlain,
+	".markdown": extractPlain,
+	".csv":      extractPlain,
+	".html":     extractHTML,
+	".htm":      extractHTML,
+	".pdf":      extractPDF,
+	".docx":     extractDOCX,
+}
+
+// Supported reports whether text can be extracted from files with extension ext
+func Supported(ext string) bool {
+	_, ok := extractors[strings.ToLower(ext)]
+	return ok
+}
+
+// Extract returns the text of the file at path, chosen by its extension and
+// truncated to MaxTextSize. The text is valid UTF-8 without NUL bytes, so it
+// can be stored as is.
+func Extract(path, ext string) (string, error) {
+	extract, ok := extractors[strings.ToLower(ext)]
+	if !ok {
+		return "", nil
+	}
+	text, err := safeExtract(extract, path)
+	if err != nil {
+		return "", err
+	}
+	return clean(text), nil
+}
+
+// safeExtract keeps a panicking parser (malformed input) from taking the
+// process down
+func safeExtract(extract func(path string) (string, error), path string) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return extract(path)
+}
+
+// clean makes text safe to store and cuts it at MaxTextSize on a rune boundary
+func clean(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.ReplaceAll(text, "\x00", "")
+	if len(text) > MaxTextSize {
+		cut := MaxTextSize
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	return strings.TrimSpace(text)
+}
+
+func extractPlain(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxTextSize))
+	return string(data), err
+}
+
+// extractHTML collects the text nodes of a page, skipping scripts and styles
+func extractHTML(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var sb strings.Builder
+	skip := 0
+	tokenizer := html.NewTokenizer(f)
+	for sb.Len() < MaxTextSize {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return sb.String(), nil
+			}
+			return sb.String(), tokenizer.Err()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				if text := strings.TrimSpace(html.UnescapeString(string(tokenizer.Text()))); text != "" {
+					sb.WriteString(text)
+					sb.WriteByte(' ')
+				}
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// extractPDF reads the text page by page, stopping once MaxTextSize is
+// reached rather than building the text of the whole document
+func extractPDF(path string) (string, error) {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var sb strings.Builder
+	fonts := map[string]*pdf.Font{}
+	for i := 1; i <= r.NumPage() && sb.Len() < MaxTextSize; i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() { // parse each font's charmap once
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteString(text)
+	}
+	return sb.String(), nil
+}
+
+// extractDOCX reads the runs of text (w:t) from word/document.xml, with one
+// line per paragraph
+func extractDOCX(path string) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		var sb strings.Builder
+		inText := false
+		decoder := xml.NewDecoder(io.LimitReader(rc, maxDocumentXMLSize))
+		for sb.Len() < MaxTextSize {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return sb.String(), err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				inText = t.Name.Local == "t"
+				if t.Name.Local == "tab" {
+					sb.WriteByte('\t')
+				}
+			case xml.EndElement:
+				inText = false
+				if t.Name.Local == "p" {
+					sb.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					sb.Write(t)
+				}
+			}
+		}
+		return sb.String(), nil
+	}
+	return "", ErrNotFound
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/models"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// archiveManifestName is the entry listing every archived file and its hash
+const archiveManifestName = "manifest.json"
+
+// ArchiveRequest selects the files to bundle: explicit IDs, a folder (with
+// its subfolders) or a ListFiles filter. Format is "zip" (default) or "tar.gz".
+type ArchiveRequest struct {
+	IDs      []uint      `json:"ids"`
+	FolderID *uint       `json:"folder_id"`
+	Filter   *fileFilter `json:"filter"`
+	Format   string      `json:"format"`
+}
+
+// archiveEntry is a selected file together with its name inside the archive
+type archiveEntry struct {
+	file models.File
+	name string
+}
+
+// archiveWriter abstracts the zip and tar.gz writers
+type archiveWriter interface {
+	add(name string, size int64, modified time.Time, store bool) (io.Writer, error)
+	Close() error
+}
+
+// DownloadArchive handles downloading several files as a single ZIP or
+// tar.gz archive, streamed as it is built. Selection comes from the JSON body
+// (POST) or from the ids, folder_id, extension, mime_type and format query
+// parameters (GET).
+func (h *FileHandler) DownloadArchive(c *gin.Context) {
+	req, err := parseArchiveRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	format := strings.ToLower(req.Format)
+	switch format {
+	case "", "zip":
+		format = "zip"
+	case "tar.gz", "tgz":
+		format = "tar.gz"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("Unsupported archive format: %s", req.Format),
+		})
+		return
+	}
+
+	cfg := h.requestConfig(c)
+	entries, err := h.selectArchiveEntries(cfg, req)
+	if err != nil {
+		h.respondItemError(c, err, "Failed to select files")
+		return
+	}
+
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   true,
+			"message": "No files matched the selection",
+		})
+		return
+	}
+
+	var totalSize int64
+	for _, entry := range entries {
+		totalSize += entry.file.Size
+	}
+	if maxSize := cfg.MaxArchiveSize; maxSize > 0 && totalSize > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("Selected files exceed maximum archive size: %d bytes", maxSize),
+		})
+		return
+	}
+
+	if h.egressQuotaExceeded(c) {
+		return
+	}
+
+	archiveName := fmt.Sprintf("files-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", archiveName))
+	if format == "zip" {
+		c.Header("Content-Type", "application/zip")
+	} else {
+		c.Header("Content-Type", "application/gzip")
+	}
+	c.Status(http.StatusOK)
+
+	var archive archiveWriter
+	if format == "zip" {
+		archive = &zipArchive{zip.NewWriter(c.Writer)}
+	} else {
+		archive = newTarGzArchive(c.Writer)
+	}
+
+	// The content of each file sent counts as egress, even when the archive
+	// is cut short
+	sent := make(map[uint]int64, len(entries))
+	defer h.recordEgress(c, sent)
+
+	// Headers are already sent, so from here on problems can only be logged
+	// and reported in the manifest
+	manifest := make([]gin.H, 0, len(entries))
+	written := 0
+	for _, entry := range entries {
+		item := gin.H{
+			"id":   entry.file.ID,
+			"name": entry.name,
+			"size": entry.file.Size,
+			"md5":  entry.file.Hash,
+		}
+		n, err := h.writeArchiveEntry(c, archive, entry)
+		sent[entry.file.ID] += n
+		if err != nil {
+			if !errors.Is(err, errEntryUnavailable) {
+				// Part of the entry went out, or the stream itself failed:
+				// later entries would be misaligned, so the archive is left
+				// unfinished for the client to notice
+				h.log(c).Error("Failed to write file to archive, aborting it:", err)
+				return
+			}
+			metrics.StorageError(metrics.OpRead)
+			h.log(c).Warn("Failed to add file to archive:", err)
+			item["error"] = "File not available"
+		} else {
+			written++
+		}
+		manifest = append(manifest, item)
+	}
+
+	manifestData, _ := json.MarshalIndent(gin.H{
+		"created_at": time.Now().Format(time.RFC3339),
+		"files":      manifest,
+	}, "", "  ")
+	if w, err := archive.add(archiveManifestName, int64(len(manifestData)), time.Now(), false); err == nil {
+		w.Write(manifestData)
+	}
+
+	if err := archive.Close(); err != nil {
+		h.log(c).Warn("Failed to finish archive:", err)
+		return
+	}
+
+	h.log(c).Infof("Archive downloaded: %s (%d of %d files, %d bytes)", archiveName, written, len(entries), totalSize)
+}
+
+// parseArchiveRequest reads the selection from the JSON body or the query string
+func parseArchiveRequest(c *gin.Context) (*ArchiveRequest, error) {
+	var req ArchiveRequest
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return nil, fmt.Errorf("Invalid request body")
+		}
+		return &req, nil
+	}
+
+	if idsParam := c.Query("ids"); idsParam != "" {
+		for _, idStr := range strings.Split(idsParam, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(idStr), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid file ID: %s", idStr)
+			}
+			req.IDs = append(req.IDs, uint(id))
+		}
+	}
+
+	folderParam := c.Query("folder_id")
+	if folderParam != "" && folderParam != "root" {
+		id, err := strconv.ParseUint(folderParam, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid folder ID")
+		}
+		folderID := uint(id)
+		req.FolderID = &folderID
+	}
+
+	if folderParam == "root" || c.Query("extension") != "" || c.Query("mime_type") != "" {
+		req.Filter = &fileFilter{
+			FolderID:  folderParam,
+			Extension: c.Query("extension"),
+			MimeType:  c.Query("mime_type"),
+		}
+	}
+
+	req.Format = c.Query("format")
+	return &req, nil
+}
+
+// selectArchiveEntries resolves the request to files and gives each one a
+// unique name inside the archive
+func (h *FileHandler) selectArchiveEntries(cfg *config.Config, req *ArchiveRequest) ([]archiveEntry, error) {
+	query := h.db.Model(&models.File{})
+	baseFolderPath := ""
+
+	switch {
+	case len(req.IDs) > 0:
+		query = query.Where("id IN ?", req.IDs)
+	case req.FolderID != nil:
+		folder, err := findFolder(h.db, *req.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := subtreeFolderIDs(h.db, folder)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("folder_id IN ?", ids)
+		baseFolderPath = folder.Path
+	case req.Filter != nil:
+		var err error
+		query, err = filterFiles(query, *req.Filter)
+		if err != nil {
+			return nil, &itemError{status: http.StatusBadRequest, message: err.Error()}
+		}
+	default:
+		return nil, &itemError{status: http.StatusBadRequest, message: "Select files with ids, folder_id or filter"}
+	}
+
+	// Fetch one more than allowed to detect selections over the cap
+	maxFiles := cfg.MaxArchiveFiles
+	var files []models.File
+	if err := query.Session(&gorm.Session{}).
+		Order("virtual_path ASC, id ASC").
+		Limit(maxFiles + 1).
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	if len(files) > maxFiles {
+		return nil, &itemError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("Too many files selected: maximum is %d per archive", maxFiles),
+		}
+	}
+
+	used := map[string]bool{archiveManifestName: true}
+	entries := make([]archiveEntry, 0, len(files))
+	for _, file := range files {
+		name := file.OriginalName
+		if baseFolderPath != "" && strings.HasPrefix(file.VirtualPath, baseFolderPath+"/") {
+			// Keep the folder structure below the selected folder
+			name = strings.TrimPrefix(file.VirtualPath, baseFolderPath+"/")
+		}
+		entries = append(entries, archiveEntry{
+			file: file,
+			name: uniqueArchiveName(used, sanitizeArchiveName(name)),
+		})
+	}
+
+	return entries, nil
+}
+
+// errEntryUnavailable is returned by writeArchiveEntry when a file can't be
+// read before anything of it is written, so the archive can go on without it
+var errEntryUnavailable = errors.New("file not available")
+
+// writeArchiveEntry copies one file from disk into the archive, through the
+// download throttles, and returns the bytes copied. Failures other than
+// errEntryUnavailable leave the archive unusable.
+func (h *FileHandler) writeArchiveEntry(c *gin.Context, archive archiveWriter, entry archiveEntry) (int64, error) {
+	src, err := os.Open(entry.file.Path)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errEntryUnavailable, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errEntryUnavailable, err)
+	}
+
+	w, err := archive.add(entry.name, info.Size(), entry.file.UpdatedAt, isCompressedMimeType(entry.file.MimeType))
+	if err != nil {
+		return 0, err
+	}
+
+	content := h.downloadReader(c, src)
+	_, err = io.CopyN(w, content, info.Size())
+	return content.N(), err
+}
+
+// sanitizeArchiveName keeps entry names relative and free of ".." segments
+func sanitizeArchiveName(name string) string {
+	cleaned := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "" || cleaned == "." {
+		return "file"
+	}
+	return cleaned
+}
+
+// uniqueArchiveName returns name, or "name (n).ext" when it is already used
+func uniqueArchiveName(used map[string]bool, name string) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// isCompressedMimeType reports whether content is already compressed, in
+// which case deflating it again only costs CPU
+func isCompressedMimeType(mimeType string) bool {
+	switch {
+	case mimeType == "image/jpeg", mimeType == "image/png", mimeType == "image/gif", mimeType == "image/webp":
+		return true
+	case strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		return true
+	case mimeType == "application/zip", mimeType == "application/gzip", mimeType == "application/x-gzip",
+		mimeType == "application/x-7z-compressed", mimeType == "application/x-rar-compressed":
+		return true
+	}
+	return false
+}
+
+// zipArchive writes ZIP entries. archive/zip switches to Zip64 records on its
+// own when an entry or the archive grows past the classic ZIP limits.
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(name string, size int64, modified time.Time, store bool) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	if store {
+		header.Method = zip.Store
+	}
+	header.UncompressedSize64 = uint64(size)
+	return a.w.CreateHeader(header)
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+// tarGzArchive writes gzip-compressed tar entries
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchive(w io.Writer) *tarGzArchive {
+	gz := gzip.NewWriter(w)
+	return &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a *tarGzArchive) add(name string, size int64, modified time.Time, store bool) (io.Writer, error) {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modified,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a *tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
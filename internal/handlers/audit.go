@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// recordAudit stores an audit trail entry using tx, so it commits or rolls
+// back together with the change it describes
+func recordAudit(tx *gorm.DB, c *gin.Context, action, resourceType string, resourceID uint, details map[string]interface{}) error {
+	return tx.Create(&models.AuditLog{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Actor:        requestActor(c),
+		Details:      details,
+	}).Error
+}
+
+// ListAuditLogs handles listing audit trail entries, newest first. Results can
+// be filtered by resource_type, resource_id and action.
+func (h *FileHandler) ListAuditLogs(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
+	query := h.db.Model(&models.AuditLog{})
+	if resourceType := c.Query("resource_type"); resourceType != "" {
+		query = query.Where("resource_type = ?", resourceType)
+	}
+	if resourceIDStr := c.Query("resource_id"); resourceIDStr != "" {
+		resourceID, err := strconv.ParseUint(resourceIDStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": "Invalid resource ID",
+			})
+			return
+		}
+		query = query.Where("resource_id = ?", uint(resourceID))
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count audit logs:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to count audit logs",
+		})
+		return
+	}
+
+	var entries []models.AuditLog
+	if err := query.Limit(limit).Offset(offset).Order("created_at DESC, id DESC").Find(&entries).Error; err != nil {
+		h.log(c).Error("Failed to list audit logs:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list audit logs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"entries": entries,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BatchOperation is a single entry of a batch request.
+//
+//	get:     {"op": "get", "id": 1}
+//	delete:  {"op": "delete", "id": 1}                 (moves the file to the trash)
+//	restore: {"op": "restore", "id": 1}
+//	tag:     {"op": "tag", "id": 1, "add": ["a"], "remove": ["b"]}
+//	move:    {"op": "move", "id": 1, "folder_id": 2}   (null moves to the root)
+type BatchOperation struct {
+	Op       string          `json:"op"`
+	ID       uint            `json:"id"`
+	Add      []string        `json:"add"`
+	Remove   []string        `json:"remove"`
+	FolderID json.RawMessage `json:"folder_id"`
+}
+
+// BatchRequest is the body accepted by BatchFiles. When Atomic is set, either
+// every operation is applied or none is.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+	Atomic     bool             `json:"atomic"`
+}
+
+// itemError is a per-item failure (batch operation, uploaded file) carrying
+// the HTTP status it would have had as a standalone request
+type itemError struct {
+	status  int
+	message string
+	fileID  uint
+}
+
+func (e *itemError) Error() string {
+	return e.message
+}
+
+// errBatchRollback aborts the outer transaction of an atomic batch
+var errBatchRollback = errors.New("batch rolled back")
+
+// BatchFiles handles running several file operations in one request. Each
+// operation reports its own result; a failing item does not stop the others
+// unless the batch is atomic.
+func (h *FileHandler) BatchFiles(c *gin.Context) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	if len(req.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "No operations provided",
+		})
+		return
+	}
+
+	if maxSize := h.requestConfig(c).MaxBatchSize; len(req.Operations) > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("Batch exceeds maximum size: %d operations", maxSize),
+		})
+		return
+	}
+
+	results := make([]gin.H, len(req.Operations))
+	failed := 0
+
+	run := func(db *gorm.DB) {
+		for i, op := range req.Operations {
+			var data gin.H
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				data, err = h.runBatchOperation(tx, c, op)
+				return err
+			})
+
+			result := gin.H{
+				"index": i,
+				"op":    op.Op,
+				"id":    op.ID,
+			}
+			if err != nil {
+				failed++
+				status, message := http.StatusInternalServerError, "Operation failed"
+				var itemErr *itemError
+				if errors.As(err, &itemErr) {
+					status, message = itemErr.status, itemErr.message
+				} else if isNameConflict(err) {
+					status, message = http.StatusConflict, "An item with this name already exists in this folder"
+				} else if isContentConflict(err) {
+					status, message = http.StatusConflict, "File already exists"
+				} else {
+					h.log(c).Error("Batch operation failed:", err)
+				}
+				result["success"] = false
+				result["status"] = status
+				result["message"] = message
+			} else {
+				result["success"] = true
+				result["status"] = http.StatusOK
+				if data != nil {
+					result["data"] = data
+				}
+			}
+			results[i] = result
+		}
+	}
+
+	if req.Atomic {
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			run(tx)
+			if failed > 0 {
+				return errBatchRollback
+			}
+			return nil
+		})
+		if err != nil {
+			if err != errBatchRollback {
+				h.log(c).Error("Failed to commit batch:", err)
+			}
+			for _, result := range results {
+				if result["success"] == true {
+					result["success"] = false
+					result["status"] = http.StatusConflict
+					result["message"] = "Rolled back"
+					delete(result, "data")
+				}
+			}
+			failed = len(results)
+		}
+	} else {
+		run(h.db)
+	}
+	h.notifyEvents()
+
+	summary := gin.H{
+		"total":     len(results),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"atomic":    req.Atomic,
+	}
+
+	// One aggregated audit entry and log line for the whole batch
+	counts := map[string]int{}
+	for _, op := range req.Operations {
+		counts[op.Op]++
+	}
+	if err := recordAudit(h.db, c, "file.batch", "file", 0, map[string]interface{}{
+		"operations": counts,
+		"summary":    summary,
+		"results":    batchAuditResults(results),
+	}); err != nil {
+		h.log(c).Warn("Failed to record batch audit entry:", err)
+	}
+	h.log(c).Infof("Batch processed: %d operations, %d succeeded, %d failed %v", len(results), len(results)-failed, failed, counts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": failed == 0,
+		"data": gin.H{
+			"results": results,
+			"summary": summary,
+		},
+	})
+}
+
+// runBatchOperation applies one batch operation inside tx and returns the data
+// to report for the item. Deleted files go to the trash with their content
+// kept on disk, so they can be brought back with a restore operation.
+func (h *FileHandler) runBatchOperation(tx *gorm.DB, c *gin.Context, op BatchOperation) (gin.H, error) {
+	if op.ID == 0 {
+		return nil, &itemError{status: http.StatusBadRequest, message: "Invalid file ID"}
+	}
+
+	switch op.Op {
+	case "get":
+		file, err := findBatchFile(tx, op.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		return formatFile(*file), nil
+
+	case "delete":
+		file, err := findBatchFile(tx, op.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Delete(file).Error; err != nil {
+			return nil, err
+		}
+		return nil, h.enqueueFileEvents(tx, c, models.EventFileDeleted, *file)
+
+	case "restore":
+		file, err := findBatchFile(tx, op.ID, true)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+			return nil, &itemError{status: http.StatusGone, message: "File content is no longer available"}
+		}
+		if file.FolderID != nil {
+			var count int64
+			if err := tx.Model(&models.Folder{}).Where("id = ?", *file.FolderID).Count(&count).Error; err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, &itemError{status: http.StatusConflict, message: "Folder is in the trash, restore it first"}
+			}
+		}
+		var count int64
+		if err := tx.Model(&models.File{}).Where("hash = ?", file.Hash).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, &itemError{status: http.StatusConflict, message: "File already exists"}
+		}
+		taken, err := h.nameTakenInFolder(tx, file.FolderID, file.OriginalName, 0, file.ID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, &itemError{status: http.StatusConflict, message: fmt.Sprintf("An item named %s already exists in this folder", file.OriginalName)}
+		}
+		if err := tx.Unscoped().Model(file).Update("deleted_at", nil).Error; err != nil {
+			return nil, err
+		}
+		file.DeletedAt = gorm.DeletedAt{}
+		if err := h.enqueueFileEvents(tx, c, models.EventFileRestored, *file); err != nil {
+			return nil, err
+		}
+		return formatFile(*file), nil
+
+	case "tag":
+		file, err := findBatchFile(tx, op.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		tags := applyTags(file.Tags, op.Add, op.Remove)
+		if err := tx.Model(file).Select("tags", "revision").Updates(&models.File{
+			Tags:     tags,
+			Revision: file.Revision + 1,
+		}).Error; err != nil {
+			return nil, err
+		}
+		file.Tags = tags
+		file.Revision++
+		return formatFile(*file), nil
+
+	case "move":
+		file, err := findBatchFile(tx, op.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.moveFile(tx, file, op.FolderID); err != nil {
+			return nil, err
+		}
+		return formatFile(*file), nil
+	}
+
+	return nil, &itemError{status: http.StatusBadRequest, message: fmt.Sprintf("Unknown operation: %s", op.Op)}
+}
+
+// moveFile moves file into the folder identified by the raw folder_id value
+// (null for the root), keeping names unique within the target folder
+func (h *FileHandler) moveFile(tx *gorm.DB, file *models.File, rawFolderID json.RawMessage) error {
+	if len(rawFolderID) == 0 {
+		return &itemError{status: http.StatusBadRequest, message: "folder_id is required"}
+	}
+
+	var folderID *uint
+	folderPath := ""
+	if string(rawFolderID) != "null" {
+		var id uint
+		if err := json.Unmarshal(rawFolderID, &id); err != nil {
+			return &itemError{status: http.StatusBadRequest, message: "Invalid folder ID"}
+		}
+		folder, err := findFolder(tx, id)
+		if err != nil {
+			return err
+		}
+		folderID = &folder.ID
+		folderPath = folder.Path
+	}
+
+	if sameFolder(folderID, file.FolderID) {
+		return nil
+	}
+
+	taken, err := h.nameTakenInFolder(tx, folderID, file.OriginalName, 0, file.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return &itemError{status: http.StatusConflict, message: fmt.Sprintf("An item named %s already exists in this folder", file.OriginalName)}
+	}
+
+	return tx.Model(file).Updates(map[string]interface{}{
+		"folder_id":    folderID,
+		"virtual_path": joinVirtualPath(folderPath, file.OriginalName),
+		"revision":     file.Revision + 1,
+	}).Error
+}
+
+// findFolder loads a live folder, reporting a missing one as an itemError
+func findFolder(tx *gorm.DB, id uint) (*models.Folder, error) {
+	var folder models.Folder
+	if err := tx.First(&folder, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &itemError{status: http.StatusNotFound, message: "Folder not found"}
+		}
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// findBatchFile loads a live file, or a trashed one when trashed is set
+func findBatchFile(tx *gorm.DB, id uint, trashed bool) (*models.File, error) {
+	query := tx
+	if trashed {
+		query = tx.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	var file models.File
+	if err := query.First(&file, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			if trashed {
+				return nil, &itemError{status: http.StatusNotFound, message: "File not found in trash"}
+			}
+			return nil, &itemError{status: http.StatusNotFound, message: "File not found"}
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+// applyTags returns tags with add appended and remove dropped, without
+// duplicates and preserving order
+func applyTags(tags, add, remove []string) []string {
+	removed := map[string]bool{}
+	for _, tag := range remove {
+		removed[strings.TrimSpace(tag)] = true
+	}
+
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range append(append([]string{}, tags...), add...) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || removed[tag] || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// batchAuditResults reduces per-item results to what the audit trail needs
+func batchAuditResults(results []gin.H) []gin.H {
+	entries := make([]gin.H, 0, len(results))
+	for _, result := range results {
+		entries = append(entries, gin.H{
+			"op":      result["op"],
+			"id":      result["id"],
+			"success": result["success"],
+			"status":  result["status"],
+		})
+	}
+	return entries
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/ratelimit"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// egressDay formats t as the day egress is accounted under
+func egressDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// monthStart returns the first day of t's month (UTC)
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// egressThisMonth returns the bytes tenant downloaded since the start of the
+// month
+func (h *FileHandler) egressThisMonth(ctx context.Context, tenant string) (int64, error) {
+	var bytes int64
+	err := h.db.WithContext(ctx).Model(&models.EgressUsage{}).
+		Where("tenant_id = ? AND day >= ?", tenant, egressDay(monthStart(time.Now()))).
+		Select("COALESCE(SUM(bytes), 0)").
+		Scan(&bytes).Error
+	return bytes, err
+}
+
+// egressQuotaExceeded refuses with 429 a download by a tenant that already
+// used its MONTHLY_EGRESS_QUOTA, until the month ends. The download crossing
+// the quota still completes. Requests with the admin token aren't counted
+// against it.
+func (h *FileHandler) egressQuotaExceeded(c *gin.Context) bool {
+	quota := h.requestConfig(c).MonthlyEgressQuota
+	if quota <= 0 || h.isPrivileged(c) {
+		return false
+	}
+
+	used, err := h.egressThisMonth(c.Request.Context(), requestTenant(c))
+	if err != nil {
+		h.log(c).Warn("Failed to check egress quota:", err)
+		return false
+	}
+	if used < quota {
+		return false
+	}
+
+	metrics.RateLimited(ratelimit.ClassDownload, metrics.LimitEgress)
+	now := time.Now()
+	h.tooManyRequests(c, monthStart(now).AddDate(0, 1, 0).Sub(now),
+		fmt.Sprintf("Monthly egress quota of %d bytes exceeded", quota))
+	return true
+}
+
+// downloadReader reads r through the download throttles of the request's
+// tenant and of its client IP, counting the bytes sent. X-Tenant-ID isn't
+// verified, so switching tenants doesn't lift the IP's throttle.
+func (h *FileHandler) downloadReader(c *gin.Context, r io.Reader) *ratelimit.Reader {
+	throttles := h.limiter.DownloadThrottles("tenant:"+requestTenant(c), "ip:"+c.ClientIP())
+	return ratelimit.NewReader(c.Request.Context(), r, throttles...)
+}
+
+// recordEgress adds the bytes of each file sent by a download to the
+// request's tenant, counting one download per file. Failures are only
+// logged: the download itself already happened.
+func (h *FileHandler) recordEgress(c *gin.Context, sent map[uint]int64) {
+	tenant, day := requestTenant(c), egressDay(time.Now())
+	usage := make([]models.EgressUsage, 0, len(sent))
+	for fileID, bytes := range sent {
+		if bytes > 0 {
+			usage = append(usage, models.EgressUsage{TenantID: tenant, Day: day, FileID: fileID, Bytes: bytes, Downloads: 1})
+		}
+	}
+	if len(usage) == 0 {
+		return
+	}
+
+	err := h.db.WithContext(context.WithoutCancel(c.Request.Context())).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tenant_id"}, {Name: "day"}, {Name: "file_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes":     gorm.Expr("egress_usage.bytes + excluded.bytes"),
+			"downloads": gorm.Expr("egress_usage.downloads + excluded.downloads"),
+		}),
+	}).Create(&usage).Error
+	if err != nil {
+		h.log(c).Warn("Failed to record egress:", err)
+	}
+}
+
+// egressStats summarizes the request tenant's egress this month: total
+// bytes and downloads, the quota and the most downloaded files
+func (h *FileHandler) egressStats(c *gin.Context) (gin.H, error) {
+	ctx := c.Request.Context()
+	tenant, since := requestTenant(c), egressDay(monthStart(time.Now()))
+
+	var totals struct {
+		Bytes     int64
+		Downloads int64
+	}
+	if err := h.db.WithContext(ctx).Model(&models.EgressUsage{}).
+		Select("COALESCE(SUM(bytes), 0) AS bytes, COALESCE(SUM(downloads), 0) AS downloads").
+		Where("tenant_id = ? AND day >= ?", tenant, since).
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+
+	type fileEgress struct {
+		FileID    uint   `json:"file_id"`
+		Name      string `json:"name"`
+		Bytes     int64  `json:"bytes"`
+		Downloads int64  `json:"downloads"`
+	}
+	topFiles := []fileEgress{}
+	if err := h.db.WithContext(ctx).Table("egress_usage").
+		Select("egress_usage.file_id, COALESCE(MAX(files.original_name), '') AS name, SUM(egress_usage.bytes) AS bytes, SUM(egress_usage.downloads) AS downloads").
+		Joins("LEFT JOIN files ON files.id = egress_usage.file_id").
+		Where("egress_usage.tenant_id = ? AND egress_usage.day >= ?", tenant, since).
+		Group("egress_usage.file_id").
+		Order("bytes DESC").
+		Limit(10).
+		Scan(&topFiles).Error; err != nil {
+		return nil, err
+	}
+
+	quota := h.requestConfig(c).MonthlyEgressQuota
+	stats := gin.H{
+		"tenant":     tenant,
+		"month":      since[:7],
+		"bytes":      totals.Bytes,
+		"downloads":  totals.Downloads,
+		"top_files":  topFiles,
+		"quota":      quota,
+		"quota_left": nil,
+	}
+	if quota > 0 {
+		stats["quota_left"] = max(quota-totals.Bytes, 0)
+	}
+	return stats, nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/events"
+	"api-file-upload-go/internal/webhooks"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventKeepAlive is how often idle streams get a comment line, so
+	// proxies and load balancers don't close them
+	eventKeepAlive = 15 * time.Second
+	// eventRetryMs is the reconnection delay suggested to clients
+	eventRetryMs = 3000
+)
+
+// StreamEvents handles GET /api/v1/events, a Server-Sent Events stream of the
+// tenant's file events (the same events webhooks receive). ?types= limits
+// the stream to a comma-separated list of event types. Clients reconnecting
+// with Last-Event-ID (or ?last_event_id=) first get the events they missed;
+// a "reset" event tells them the event log doesn't reach back that far and
+// their state should be reloaded.
+func (h *FileHandler) StreamEvents(c *gin.Context) {
+	filter := events.Filter{TenantID: requestTenant(c), Types: map[string]bool{}}
+	if raw := c.Query("types"); raw != "" {
+		for _, eventType := range strings.Split(raw, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !webhooks.IsEventType(eventType) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   true,
+					"message": fmt.Sprintf("Unknown event type: %s", eventType),
+				})
+				return
+			}
+			filter.Types[eventType] = true
+		}
+	}
+
+	lastEventID := uint64(0)
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("last_event_id")
+	}
+	if raw != "" {
+		parsed, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": "Invalid Last-Event-ID",
+			})
+			return
+		}
+		lastEventID = parsed
+	}
+
+	sub, backlog, complete, err := h.events.Subscribe(filter, uint(lastEventID))
+	if err != nil {
+		if errors.Is(err, events.ErrTooManySubscribers) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   true,
+				"message": "Too many event streams, try again later",
+			})
+			return
+		}
+		if errors.Is(err, events.ErrStopped) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   true,
+				"message": "Server is shutting down, reconnect later",
+			})
+			return
+		}
+		h.log(c).Error("Failed to subscribe to events:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to subscribe to events",
+		})
+		return
+	}
+	defer h.events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMs)
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {\"message\":\"Missed events are no longer available, reload the current state\"}\n\n")
+	}
+	for _, event := range backlog {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, or shutting down; the client
+				// reconnects and resumes from its last event
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	}
+}
+
+// writeEvent writes event in the Server-Sent Events format
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
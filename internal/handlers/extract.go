@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/tracing"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// errInvalidArchive is reported when an uploaded archive can't be read
+var errInvalidArchive = &itemError{status: http.StatusBadRequest, message: "Invalid or corrupt archive"}
+
+// archiveFormat returns the archive format of fileName ("zip", "tar" or
+// "tar.gz"), or "" when it is not an archive that can be extracted
+func archiveFormat(fileName string) string {
+	name := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// extractBudget enforces the zip-bomb limits across all entries of an archive
+type extractBudget struct {
+	entries     int
+	expanded    int64
+	archiveSize int64
+}
+
+// addToBudget accounts for one more entry of size bytes, failing once the
+// archive goes over the entry count, expanded size or compression ratio limits
+func addToBudget(cfg *config.Config, budget *extractBudget, size int64) error {
+	budget.entries++
+	budget.expanded += size
+
+	if budget.entries > cfg.MaxExtractEntries {
+		return &itemError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("Archive has too many entries: maximum is %d", cfg.MaxExtractEntries),
+		}
+	}
+	if budget.expanded > cfg.MaxExtractSize {
+		return &itemError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("Archive exceeds maximum extracted size: %d bytes", cfg.MaxExtractSize),
+		}
+	}
+	if budget.archiveSize > 0 && budget.expanded > budget.archiveSize*int64(cfg.MaxExtractRatio) {
+		return ratioError(cfg)
+	}
+	return nil
+}
+
+// ratioError is the failure for archives that expand suspiciously well
+func ratioError(cfg *config.Config) error {
+	return &itemError{
+		status:  http.StatusRequestEntityTooLarge,
+		message: fmt.Sprintf("Archive exceeds maximum compression ratio: %d", cfg.MaxExtractRatio),
+	}
+}
+
+// expandArchives replaces every staged archive with the entries extracted
+// from it. The archive itself is never stored; when it can't be extracted it
+// stays in the list carrying the error, so it shows up in the report.
+func (h *FileHandler) expandArchives(ctx context.Context, cfg *config.Config, uploads []*stagedUpload) []*stagedUpload {
+	expanded := make([]*stagedUpload, 0, len(uploads))
+	for _, upload := range uploads {
+		if upload.err != nil || archiveFormat(upload.fileName) == "" {
+			expanded = append(expanded, upload)
+			continue
+		}
+
+		archiveCtx, span := tracing.Start(ctx, "upload.extract", attribute.String("archive.name", upload.fileName))
+		entries, err := h.extractArchive(archiveCtx, cfg, upload)
+		span.SetAttributes(attribute.Int("archive.entries", len(entries)))
+		tracing.End(span, err)
+		os.Remove(upload.path)
+		upload.path = ""
+		if err == nil && len(entries) == 0 {
+			err = &itemError{status: http.StatusBadRequest, message: "Archive contains no files"}
+		}
+		if err != nil {
+			upload.err = err
+			expanded = append(expanded, upload)
+			continue
+		}
+
+		h.logger.WithContext(ctx).Infof("Archive extracted: %s (%d entries)", upload.fileName, len(entries))
+		expanded = append(expanded, entries...)
+	}
+	return expanded
+}
+
+// extractArchive writes the regular files of a staged archive to the upload
+// directory as staged uploads of their own. Problems with a single entry are
+// recorded on that entry; exceeding a limit or a corrupt archive fails the
+// whole archive and removes whatever was already extracted.
+func (h *FileHandler) extractArchive(ctx context.Context, cfg *config.Config, upload *stagedUpload) (entries []*stagedUpload, err error) {
+	defer func() {
+		if err != nil {
+			removeStagedUploads(entries)
+			entries = nil
+		}
+	}()
+
+	budget := &extractBudget{archiveSize: upload.size}
+	timestamp := time.Now().UnixNano()
+
+	if archiveFormat(upload.fileName) == "zip" {
+		zr, err := zip.OpenReader(upload.path)
+		if err != nil {
+			return nil, errInvalidArchive
+		}
+		defer zr.Close()
+
+		for i, f := range zr.File {
+			// Every entry counts, skipped ones too, so directories and junk
+			// can't be used to get around the limits
+			if err := addToBudget(cfg, budget, int64(f.UncompressedSize64)); err != nil {
+				return entries, err
+			}
+			if f.FileInfo().IsDir() || isArchiveJunk(f.Name) {
+				continue
+			}
+			// Per-entry ratio from the declared sizes; archive/zip refuses to
+			// inflate past the declared uncompressed size
+			if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > uint64(cfg.MaxExtractRatio) {
+				return entries, ratioError(cfg)
+			}
+
+			entry := h.newArchiveEntry(upload, i, f.Name, timestamp)
+			entries = append(entries, entry)
+			if entry.err != nil {
+				continue
+			}
+			if !f.Mode().IsRegular() {
+				entry.err = &itemError{status: http.StatusBadRequest, message: "Only regular files can be extracted"}
+				continue
+			}
+			if err := h.validateUpload(cfg, entry.fileName, int64(f.UncompressedSize64)); err != nil {
+				entry.err = &itemError{status: http.StatusBadRequest, message: err.Error()}
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				entry.err = errInvalidArchive
+				continue
+			}
+			h.writeArchiveEntryUpload(ctx, cfg, entry, rc)
+			rc.Close()
+		}
+		return entries, nil
+	}
+
+	src, err := os.Open(upload.path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var r io.Reader = src
+	if archiveFormat(upload.fileName) == "tar.gz" {
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, errInvalidArchive
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, errInvalidArchive
+		}
+		// Skipped entries count too: the next call to Next decompresses
+		// their content all the same
+		if err := addToBudget(cfg, budget, header.Size); err != nil {
+			return entries, err
+		}
+		if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeXGlobalHeader || isArchiveJunk(header.Name) {
+			continue
+		}
+
+		entry := h.newArchiveEntry(upload, i, header.Name, timestamp)
+		entries = append(entries, entry)
+		if entry.err != nil {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			entry.err = &itemError{status: http.StatusBadRequest, message: "Only regular files can be extracted"}
+			continue
+		}
+		if err := h.validateUpload(cfg, entry.fileName, header.Size); err != nil {
+			entry.err = &itemError{status: http.StatusBadRequest, message: err.Error()}
+			continue
+		}
+
+		h.writeArchiveEntryUpload(ctx, cfg, entry, tr)
+	}
+	return entries, nil
+}
+
+// newArchiveEntry creates the staged upload for one archive entry, rejecting
+// absolute paths and ".." segments so nothing can escape the target folder
+func (h *FileHandler) newArchiveEntry(archive *stagedUpload, i int, entryName string, timestamp int64) *stagedUpload {
+	entry := &stagedUpload{
+		index:   archive.index,
+		archive: archive.fileName,
+		entry:   entryName,
+	}
+
+	name := strings.ReplaceAll(entryName, "\\", "/")
+	unsafe := strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':')
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			unsafe = true
+		}
+	}
+	if unsafe {
+		entry.fileName = path.Base(name)
+		entry.err = &itemError{status: http.StatusBadRequest, message: fmt.Sprintf("Unsafe path in archive: %s", entryName)}
+		return entry
+	}
+
+	name = path.Clean(name)
+	entry.fileName = path.Base(name)
+	if dir := path.Dir(name); dir != "." {
+		entry.dir = dir
+	}
+	if err := validateFileName(entry.fileName); err != nil {
+		entry.err = &itemError{status: http.StatusBadRequest, message: err.Error()}
+		return entry
+	}
+
+	entry.diskName = fmt.Sprintf("%d_%d_e%d_%s", timestamp, archive.index, i, entry.fileName)
+	return entry
+}
+
+// writeArchiveEntryUpload streams an entry's content to disk, recording any
+// failure on the entry
+func (h *FileHandler) writeArchiveEntryUpload(ctx context.Context, cfg *config.Config, entry *stagedUpload, r io.Reader) {
+	var err error
+	maxSize := cfg.MaxFileSize
+	entry.path, entry.hash, entry.size, err = h.writeUpload(ctx, cfg, r, entry.diskName, maxSize)
+	switch {
+	case err == errFileTooLarge:
+		entry.err = &itemError{
+			status:  http.StatusBadRequest,
+			message: fmt.Sprintf("File size exceeds maximum allowed size: %d bytes", maxSize),
+		}
+	case err != nil:
+		entry.err = &itemError{status: http.StatusInternalServerError, message: err.Error()}
+	}
+}
+
+// isArchiveJunk reports entries added by archivers that aren't user content
+func isArchiveJunk(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || path.Base(name) == ".DS_Store"
+}
+
+// ensureFolderPath returns the folder at dir (a relative "a/b" path) below
+// parentID, creating the missing folders along the way. When a concurrent
+// upload creates one of them first, the path is looked up again.
+func (h *FileHandler) ensureFolderPath(parentID *uint, dir string) (*models.Folder, error) {
+	folder, err := h.createFolderPath(parentID, dir)
+	if isNameConflict(err) {
+		folder, err = h.createFolderPath(parentID, dir)
+	}
+	return folder, err
+}
+
+// createFolderPath is ensureFolderPath in a single transaction
+func (h *FileHandler) createFolderPath(parentID *uint, dir string) (*models.Folder, error) {
+	var folder *models.Folder
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		currentID := parentID
+		currentPath := ""
+		if parentID != nil {
+			parent, err := findFolder(tx, *parentID)
+			if err != nil {
+				return err
+			}
+			currentPath = parent.Path
+		}
+
+		for _, name := range strings.Split(dir, "/") {
+			if err := validateFolderName(name); err != nil {
+				return &itemError{status: http.StatusBadRequest, message: err.Error()}
+			}
+
+			query := tx.Where("name = ?", name)
+			if currentID == nil {
+				query = query.Where("parent_id IS NULL")
+			} else {
+				query = query.Where("parent_id = ?", *currentID)
+			}
+
+			var existing models.Folder
+			err := query.First(&existing).Error
+			switch {
+			case err == nil:
+				folder = &existing
+			case err == gorm.ErrRecordNotFound:
+				taken, err := h.nameTakenInFolder(tx, currentID, name, 0, 0)
+				if err != nil {
+					return err
+				}
+				if taken {
+					return &itemError{status: http.StatusConflict, message: fmt.Sprintf("An item named %s already exists in this folder", name)}
+				}
+				folder = &models.Folder{
+					Name:     name,
+					ParentID: currentID,
+					Path:     joinVirtualPath(currentPath, name),
+				}
+				if err := tx.Create(folder).Error; err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+
+			currentID = &folder.ID
+			currentPath = folder.Path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
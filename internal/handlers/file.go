@@ -2,187 +2,243 @@ package handlers
 
 import (
 	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/events"
+	"api-file-upload-go/internal/health"
+	"api-file-upload-go/internal/metrics"
 	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/progress"
+	"api-file-upload-go/internal/ratelimit"
+	"api-file-upload-go/internal/tracing"
 	"api-file-upload-go/internal/utils"
-	"crypto/md5"
+	"api-file-upload-go/internal/webhooks"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/gorm"
 )
 
 type FileHandler struct {
-	config *config.Config
+	// config is swapped by SetConfig when the configuration is reloaded
+	config atomic.Pointer[config.Config]
 	db     *gorm.DB
 	logger *logrus.Logger
+
+	// mediaSlots bounds the background media extractions running at once
+	mediaSlots chan struct{}
+
+	// webhooks delivers the events recorded with enqueueFileEvents and
+	// events streams them to connected clients
+	webhooks *webhooks.Dispatcher
+	events   *events.Broker
+
+	// uploads tracks the progress of upload requests
+	uploads *progress.Registry
+
+	// draining is set once shutdown has begun: new uploads are refused and
+	// readiness fails
+	draining atomic.Bool
+
+	// health runs the readiness and health checks
+	health  *health.Checker
+	started time.Time
+
+	// limiter enforces the rate limits, upload caps and bandwidth quotas
+	limiter *ratelimit.Limiter
 }
 
-func NewFileHandler(cfg *config.Config, db *gorm.DB, logger *logrus.Logger) *FileHandler {
-	return &FileHandler{
-		config: cfg,
-		db:     db,
-		logger: logger,
+func NewFileHandler(cfg *config.Config, db *gorm.DB, logger *logrus.Logger, dispatcher *webhooks.Dispatcher, broker *events.Broker, uploads *progress.Registry, limiter *ratelimit.Limiter) *FileHandler {
+	h := &FileHandler{
+		db:         db,
+		logger:     logger,
+		mediaSlots: make(chan struct{}, cfg.MediaWorkers),
+		webhooks:   dispatcher,
+		events:     broker,
+		uploads:    uploads,
+		health:     newHealthChecker(cfg, db),
+		started:    time.Now(),
+		limiter:    limiter,
+	}
+	h.config.Store(cfg)
+	return h
+}
+
+// SetConfig swaps the configuration read by requests starting from now on,
+// when it is reloaded
+func (h *FileHandler) SetConfig(cfg *config.Config) {
+	h.config.Store(cfg)
+}
+
+// configKey keeps the configuration a request started with in its context
+const configKey = "config"
+
+// requestConfig returns the configuration snapshot of the request, taken the
+// first time it is asked for, so a reload in the middle of a request can't
+// mix settings from two configurations
+func (h *FileHandler) requestConfig(c *gin.Context) *config.Config {
+	if cfg, ok := c.Get(configKey); ok {
+		return cfg.(*config.Config)
 	}
+	cfg := h.config.Load()
+	c.Set(configKey, cfg)
+	return cfg
 }
 
-// UploadFile handles file upload
+// Drain marks the server as shutting down: uploads not yet started are
+// refused with 503 and readiness fails so load balancers stop sending
+// traffic. Requests already running are left to finish.
+func (h *FileHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// UploadFile handles file upload. Several "file" parts can be sent in one
+// request; each one is streamed to disk, validated and stored independently.
+// With ?extract=true, ZIP/tar/tar.gz parts are unpacked and every entry is
+// stored as a file of its own, recreating the archive's directories.
 func (h *FileHandler) UploadFile(c *gin.Context) {
-	// Get uploaded file
-	file, err := c.FormFile("file")
+	extract := c.Query("extract") == "true"
+	ctx, span := tracing.Start(c.Request.Context(), "upload.receive")
+	uploads, fields, err := h.readUploadParts(ctx, c, extract)
+	span.SetAttributes(attribute.Int("upload.files", len(uploads)))
+	tracing.End(span, err)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   true,
-			"message": "No file uploaded",
-		})
+		h.respondItemError(c, err, "Failed to read upload")
 		return
 	}
 
-	// Check file size (only if MaxFileSize is defined)
-	if h.config.MaxFileSize > 0 && file.Size > h.config.MaxFileSize {
+	if len(uploads) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   true,
-			"message": fmt.Sprintf("File size exceeds maximum allowed size: %d bytes", h.config.MaxFileSize),
+			"message": "No file uploaded",
 		})
 		return
 	}
 
-	// Get file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-
-	// Check file extension (only if AllowedExtensions is defined)
-	if len(h.config.AllowedExtensions) > 0 {
-		if !utils.Contains(h.config.AllowedExtensions, ext) {
+	// Optional per-file options, keyed by file name or part index
+	options := uploadOptionsSet{}
+	if raw := fields["metadata"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &options); err != nil {
+			removeStagedUploads(uploads)
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error":   true,
-				"message": fmt.Sprintf("File extension not allowed: %s", ext),
+				"message": "Invalid metadata part: expected a JSON object keyed by file name",
 			})
 			return
 		}
 	}
 
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(h.config.UploadDir, 0755); err != nil {
-		h.logger.Error("Failed to create upload directory:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   true,
-			"message": "Failed to create upload directory",
-		})
-		return
+	if extract {
+		uploads = h.expandArchives(c.Request.Context(), h.requestConfig(c), uploads)
 	}
 
-	// Generate unique filename
-	fileName := fmt.Sprintf("%d_%s", time.Now().Unix(), file.Filename)
-	destPath := filepath.Join(h.config.UploadDir, fileName)
+	// Keep the original response shape for single-file uploads
+	if len(uploads) == 1 && !extract {
+		fileRecord, err := h.persistUpload(c, uploads[0], options.lookup(uploads[0]), fields["folder_id"])
+		if err != nil {
+			uploads[0].remove()
+			h.respondItemError(c, err, "Failed to save file metadata")
+			return
+		}
 
-	// Save uploaded file
-	if err := c.SaveUploadedFile(file, destPath); err != nil {
-		h.logger.Error("Failed to save uploaded file:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   true,
-			"message": "Failed to save uploaded file",
-		})
-		return
-	}
+		h.log(c).Infof("File uploaded successfully: %s (ID: %d)", fileRecord.OriginalName, fileRecord.ID)
 
-	// Calculate file hash
-	hash, err := h.calculateFileHash(destPath)
-	if err != nil {
-		// Clean up uploaded file if hash calculation fails
-		os.Remove(destPath)
-		h.logger.Error("Failed to calculate file hash:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   true,
-			"message": "Failed to calculate file hash",
-		})
-		return
-	}
-
-	// Check if file already exists
-	var existingFile models.File
-	if err := h.db.Where("hash = ?", hash).First(&existingFile).Error; err == nil {
-		// Clean up uploaded file if duplicate
-		os.Remove(destPath)
-		c.JSON(http.StatusConflict, gin.H{
-			"error":   true,
-			"message": "File already exists",
-			"file_id": existingFile.ID,
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "File uploaded successfully",
+			"file":    uploadedFileResponse(fileRecord),
 		})
 		return
 	}
 
-	// Get MIME type
-	mimeType := utils.GetMimeType(file.Filename)
+	results := make([]gin.H, 0, len(uploads))
+	failed := 0
+	for _, upload := range uploads {
+		result := gin.H{
+			"index": upload.index,
+			"name":  upload.fileName,
+		}
+		if upload.archive != "" {
+			result["archive"] = upload.archive
+			result["entry"] = upload.entry
+		}
 
-	// Create file record
-	fileRecord := models.File{
-		Name:         fileName,
-		OriginalName: file.Filename,
-		Path:         destPath,
-		Size:         file.Size,
-		MimeType:     mimeType,
-		Extension:    ext,
-		Hash:         hash,
+		fileRecord, err := h.persistUpload(c, upload, options.lookup(upload), fields["folder_id"])
+		if err != nil {
+			upload.remove()
+			failed++
+			status, message := http.StatusInternalServerError, "Failed to save file metadata"
+			var itemErr *itemError
+			if errors.As(err, &itemErr) {
+				status, message = itemErr.status, itemErr.message
+				if itemErr.fileID != 0 {
+					result["file_id"] = itemErr.fileID
+				}
+			} else {
+				h.log(c).Error("Failed to save file metadata:", err)
+			}
+			result["success"] = false
+			result["status"] = status
+			result["message"] = message
+		} else {
+			h.log(c).Infof("File uploaded successfully: %s (ID: %d)", fileRecord.OriginalName, fileRecord.ID)
+			result["success"] = true
+			result["status"] = http.StatusCreated
+			result["file"] = uploadedFileResponse(fileRecord)
+		}
+		results = append(results, result)
 	}
 
-	// Save to database
-	if err := h.db.Create(&fileRecord).Error; err != nil {
-		// Clean up uploaded file if database save fails
-		os.Remove(destPath)
-		h.logger.Error("Failed to save file metadata:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   true,
-			"message": "Failed to save file metadata",
-		})
-		return
+	status := http.StatusCreated
+	message := "Files uploaded successfully"
+	if failed > 0 {
+		status = http.StatusOK
+		message = fmt.Sprintf("%d of %d files uploaded", len(uploads)-failed, len(uploads))
 	}
 
-	h.logger.Infof("File uploaded successfully: %s (ID: %d)", fileRecord.OriginalName, fileRecord.ID)
-
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-		"message": "File uploaded successfully",
-		"file": gin.H{
-			"id":           fileRecord.ID,
-			"name":         fileRecord.OriginalName,
-			"size":         fileRecord.Size,
-			"mime_type":    fileRecord.MimeType,
-			"extension":    fileRecord.Extension,
-			"hash":         fileRecord.Hash,
-			"uploaded_at":  fileRecord.UploadedAt,
+	c.JSON(status, gin.H{
+		"success": failed == 0,
+		"message": message,
+		"data": gin.H{
+			"results": results,
+			"summary": gin.H{
+				"total":     len(uploads),
+				"succeeded": len(uploads) - failed,
+				"failed":    failed,
+			},
 		},
 	})
 }
 
 // ListFiles handles file listing with pagination
 func (h *FileHandler) ListFiles(c *gin.Context) {
-	// Parse pagination parameters
-	limitStr := c.DefaultQuery("limit", "10")
-	offsetStr := c.DefaultQuery("offset", "0")
-
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 || limit > 100 {
-		limit = 10
-	}
+	limit, offset := parsePagination(c)
 
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	query, err := filterFiles(h.db.Model(&models.File{}), fileFilterFromQuery(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
 	}
 
 	var files []models.File
 
 	// Get total count
 	var total int64
-	if err := h.db.Model(&models.File{}).Count(&total).Error; err != nil {
-		h.logger.Error("Failed to count files:", err)
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count files:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to count files",
@@ -191,8 +247,8 @@ func (h *FileHandler) ListFiles(c *gin.Context) {
 	}
 
 	// Get files with pagination
-	if err := h.db.Limit(limit).Offset(offset).Order("uploaded_at DESC").Find(&files).Error; err != nil {
-		h.logger.Error("Failed to list files:", err)
+	if err := query.Limit(limit).Offset(offset).Order("uploaded_at DESC").Find(&files).Error; err != nil {
+		h.log(c).Error("Failed to list files:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to list files",
@@ -203,16 +259,7 @@ func (h *FileHandler) ListFiles(c *gin.Context) {
 	// Format response
 	var fileList []gin.H
 	for _, file := range files {
-		fileList = append(fileList, gin.H{
-			"id":           file.ID,
-			"name":         file.OriginalName,
-			"size":         file.Size,
-			"mime_type":    file.MimeType,
-			"extension":    file.Extension,
-			"hash":         file.Hash,
-			"uploaded_at":  file.UploadedAt,
-			"download_url": fmt.Sprintf("/api/v1/files/%d/download", file.ID),
-		})
+		fileList = append(fileList, formatFile(file))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -247,7 +294,7 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 			})
 			return
 		}
-		h.logger.Error("Failed to get file:", err)
+		h.log(c).Error("Failed to get file:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to get file",
@@ -255,18 +302,10 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", fileETag(&file))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data": gin.H{
-			"id":           file.ID,
-			"name":         file.OriginalName,
-			"size":         file.Size,
-			"mime_type":    file.MimeType,
-			"extension":    file.Extension,
-			"hash":         file.Hash,
-			"uploaded_at":  file.UploadedAt,
-			"download_url": fmt.Sprintf("/api/v1/files/%d/download", file.ID),
-		},
+		"data":    formatFile(file),
 	})
 }
 
@@ -283,7 +322,7 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 	}
 
 	var file models.File
-	if err := h.db.First(&file, uint(id)).Error; err != nil {
+	if err := h.db.WithContext(c.Request.Context()).First(&file, uint(id)).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error":   true,
@@ -291,7 +330,7 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 			})
 			return
 		}
-		h.logger.Error("Failed to get file:", err)
+		h.log(c).Error("Failed to get file:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to get file",
@@ -299,23 +338,74 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
+	// Optionally serve a specific version instead of the current content
+	filePath, originalPath, mimeType := file.Path, file.OriginalPath, file.MimeType
+	if versionStr := c.Query("version"); versionStr != "" {
+		version, ok := h.loadFileVersion(c, &file, versionStr)
+		if !ok {
+			return
+		}
+		filePath, originalPath, mimeType = version.Path, version.OriginalPath, version.MimeType
+	}
+
+	// Originals retained before stripping image metadata are for admins only
+	if c.Query("original") == "true" {
+		if !h.isPrivileged(c) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   true,
+				"message": "Only administrators can download originals",
+			})
+			return
+		}
+		if originalPath == "" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "No original retained for this file",
+			})
+			return
+		}
+		filePath = originalPath
+	}
+
+	if h.egressQuotaExceeded(c) {
+		return
+	}
+
 	// Check if file exists on disk
-	if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		metrics.StorageError(metrics.OpRead)
 		c.JSON(http.StatusNotFound, gin.H{
 			"error":   true,
 			"message": "File not found on disk",
 		})
 		return
 	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		metrics.StorageError(metrics.OpRead)
+		h.log(c).Error("Failed to stat file:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to read file",
+		})
+		return
+	}
 
 	// Set headers for file download
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.OriginalName))
-	c.Header("Content-Type", file.MimeType)
-
-	// Serve file
-	c.File(file.Path)
+	c.Header("Content-Type", mimeType)
+
+	// Serve file through the download throttles, accounting the bytes sent
+	_, span := tracing.Start(c.Request.Context(), "storage.read", attribute.String("file.name", file.OriginalName))
+	content := h.downloadReader(c, src)
+	http.ServeContent(c.Writer, c.Request, file.OriginalName, info.ModTime(), content)
+	span.SetAttributes(attribute.Int("file.bytes_sent", c.Writer.Size()))
+	span.End()
+	h.recordEgress(c, map[uint]int64{file.ID: content.N()})
 }
 
 // DeleteFile handles file deletion
@@ -330,8 +420,9 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
 	var file models.File
-	if err := h.db.First(&file, uint(id)).Error; err != nil {
+	if err := h.db.WithContext(ctx).First(&file, uint(id)).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error":   true,
@@ -339,7 +430,7 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 			})
 			return
 		}
-		h.logger.Error("Failed to get file:", err)
+		h.log(c).Error("Failed to get file:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to get file",
@@ -347,14 +438,31 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	// Delete file from disk
+	// Delete file, its retained original, its older versions and its
+	// thumbnails from disk
+	_, span := tracing.Start(ctx, "storage.delete", attribute.String("file.name", file.OriginalName))
 	if err := os.Remove(file.Path); err != nil {
-		h.logger.Warn("Failed to delete file from disk:", err)
+		metrics.StorageError(metrics.OpDelete)
+		h.log(c).Warn("Failed to delete file from disk:", err)
+	}
+	if file.OriginalPath != "" {
+		if err := os.Remove(file.OriginalPath); err != nil && !os.IsNotExist(err) {
+			metrics.StorageError(metrics.OpDelete)
+			h.log(c).Warn("Failed to delete original from disk:", err)
+		}
 	}
+	h.removeRenditions(file.Hash)
+	h.removeVersionFiles(&file)
+	span.End()
 
-	// Delete from database (soft delete)
-	if err := h.db.Delete(&file).Error; err != nil {
-		h.logger.Error("Failed to delete file from database:", err)
+	// Delete from database (soft delete), recording the event with it
+	if err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&file).Error; err != nil {
+			return err
+		}
+		return h.enqueueFileEvents(tx, c, models.EventFileDeleted, file)
+	}); err != nil {
+		h.log(c).Error("Failed to delete file from database:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to delete file from database",
@@ -362,7 +470,8 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	h.logger.Infof("File deleted successfully: %s (ID: %d)", file.OriginalName, file.ID)
+	h.notifyEvents()
+	h.log(c).Infof("File deleted successfully: %s (ID: %d)", file.OriginalName, file.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -375,7 +484,7 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 	// Get total count
 	var totalFiles int64
 	if err := h.db.Model(&models.File{}).Count(&totalFiles).Error; err != nil {
-		h.logger.Error("Failed to count files:", err)
+		h.log(c).Error("Failed to count files:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to count files",
@@ -386,7 +495,7 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 	// Get total size
 	var totalSize int64
 	if err := h.db.Model(&models.File{}).Select("COALESCE(SUM(size), 0)").Scan(&totalSize).Error; err != nil {
-		h.logger.Error("Failed to calculate total size:", err)
+		h.log(c).Error("Failed to calculate total size:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to calculate total size",
@@ -405,7 +514,7 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 		Group("extension").
 		Order("count DESC").
 		Scan(&extensionStats).Error; err != nil {
-		h.logger.Error("Failed to get extension stats:", err)
+		h.log(c).Error("Failed to get extension stats:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to get extension stats",
@@ -419,7 +528,7 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 	if err := h.db.Model(&models.File{}).
 		Where("uploaded_at > ?", yesterday).
 		Count(&recentUploads).Error; err != nil {
-		h.logger.Error("Failed to count recent uploads:", err)
+		h.log(c).Error("Failed to count recent uploads:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   true,
 			"message": "Failed to count recent uploads",
@@ -431,7 +540,7 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 	var largestFile models.File
 	if err := h.db.Order("size DESC").First(&largestFile).Error; err != nil {
 		if err != gorm.ErrRecordNotFound {
-			h.logger.Error("Failed to get largest file:", err)
+			h.log(c).Error("Failed to get largest file:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   true,
 				"message": "Failed to get largest file",
@@ -440,52 +549,230 @@ func (h *FileHandler) GetStats(c *gin.Context) {
 		}
 	}
 
+	// Get this month's egress of the tenant
+	egress, err := h.egressStats(c)
+	if err != nil {
+		h.log(c).Error("Failed to get egress stats:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get egress stats",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"total_files":      totalFiles,
-			"total_size":       totalSize,
-			"recent_uploads":   recentUploads,
+			"total_files":    totalFiles,
+			"total_size":     totalSize,
+			"recent_uploads": recentUploads,
 			"largest_file": gin.H{
 				"name": largestFile.OriginalName,
 				"size": largestFile.Size,
 			},
 			"extension_stats": extensionStats,
+			"egress":          egress,
 		},
 	})
 }
 
-// HealthCheck handles health check endpoint
-func (h *FileHandler) HealthCheck(c *gin.Context) {
-	// Test database connection
-	if err := h.db.Raw("SELECT 1").Error; err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":  "error",
-			"message": "Database connection failed",
+// loadFile parses a file ID and loads the live file, writing the error
+// response itself when it fails
+func (h *FileHandler) loadFile(c *gin.Context, idStr string) (*models.File, bool) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid file ID",
 		})
-		return
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "ok",
-		"message": "API is healthy",
-		"version": "1.0.0",
-		"timestamp": time.Now().Format(time.RFC3339),
-	})
+	var file models.File
+	if err := h.db.First(&file, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "File not found",
+			})
+			return nil, false
+		}
+		h.log(c).Error("Failed to get file:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get file",
+		})
+		return nil, false
+	}
+
+	return &file, true
 }
 
-// calculateFileHash calculates MD5 hash of a file
-func (h *FileHandler) calculateFileHash(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
+// validateUpload applies the configured size and extension rules to an incoming file
+func (h *FileHandler) validateUpload(cfg *config.Config, fileName string, size int64) error {
+	// Check file size (only if MaxFileSize is defined)
+	if cfg.MaxFileSize > 0 && size > cfg.MaxFileSize {
+		metrics.UploadRejected(metrics.RejectFileTooLarge)
+		return fmt.Errorf("File size exceeds maximum allowed size: %d bytes", cfg.MaxFileSize)
+	}
+
+	// Check file extension (only if AllowedExtensions is defined)
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if len(cfg.AllowedExtensions) > 0 && !utils.Contains(cfg.AllowedExtensions, ext) {
+		metrics.UploadRejected(metrics.RejectExtension)
+		return fmt.Errorf("File extension not allowed: %s", ext)
+	}
+
+	return nil
+}
+
+// uploadedFileResponse builds the file object returned by upload endpoints
+func uploadedFileResponse(fileRecord *models.File) gin.H {
+	return gin.H{
+		"id":           fileRecord.ID,
+		"name":         fileRecord.OriginalName,
+		"size":         fileRecord.Size,
+		"mime_type":    fileRecord.MimeType,
+		"extension":    fileRecord.Extension,
+		"hash":         fileRecord.Hash,
+		"folder_id":    fileRecord.FolderID,
+		"virtual_path": fileRecord.VirtualPath,
+		"version":      fileRecord.CurrentVersion,
+		"media":        fileRecord.MediaInfo,
+		"media_status": fileRecord.MediaStatus,
+		"uploaded_at":  fileRecord.UploadedAt,
+	}
+}
+
+// isPrivileged reports whether the request carries the admin token as a
+// bearer token
+func (h *FileHandler) isPrivileged(c *gin.Context) bool {
+	adminToken := h.requestConfig(c).AdminToken
+	if adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// requestActor identifies who performed a request: the X-User-ID header when
+// present, otherwise the client IP
+func requestActor(c *gin.Context) string {
+	if user := strings.TrimSpace(c.GetHeader("X-User-ID")); user != "" {
+		return user
 	}
-	defer file.Close()
+	return c.ClientIP()
+}
+
+// maxTenantIDLength bounds the X-Tenant-ID header
+const maxTenantIDLength = 64
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+// requestTenant identifies the tenant a request acts for, from the
+// X-Tenant-ID header. Requests without one belong to the "default" tenant.
+func requestTenant(c *gin.Context) string {
+	tenant := strings.TrimSpace(c.GetHeader("X-Tenant-ID"))
+	if tenant == "" {
+		return "default"
+	}
+	if len(tenant) > maxTenantIDLength {
+		tenant = tenant[:maxTenantIDLength]
 	}
+	return tenant
+}
+
+// fileFilter holds the optional ListFiles filters
+type fileFilter struct {
+	FolderID  string            `json:"folder_id"`
+	Extension string            `json:"extension"`
+	MimeType  string            `json:"mime_type"`
+	Media     map[string]string `json:"media"`
+}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+// fileFilterFromQuery reads the ListFiles filters from the query string
+func fileFilterFromQuery(c *gin.Context) fileFilter {
+	filter := fileFilter{
+		FolderID:  c.Query("folder_id"),
+		Extension: c.Query("extension"),
+		MimeType:  c.Query("mime_type"),
+		Media:     map[string]string{},
+	}
+	for _, param := range mediaFilterParams {
+		if value := c.Query(param); value != "" {
+			filter.Media[param] = value
+		}
+	}
+	return filter
+}
+
+// filterFiles applies filter to a files query. folder_id "root" matches files
+// outside any folder; a mime_type ending in "/*" matches the whole type.
+func filterFiles(query *gorm.DB, filter fileFilter) (*gorm.DB, error) {
+	if filter.FolderID != "" {
+		if filter.FolderID == "root" {
+			query = query.Where("folder_id IS NULL")
+		} else {
+			folderID, err := strconv.ParseUint(filter.FolderID, 10, 32)
+			if err != nil {
+				return nil, errors.New("Invalid folder ID")
+			}
+			query = query.Where("folder_id = ?", uint(folderID))
+		}
+	}
+
+	if filter.Extension != "" {
+		ext := strings.ToLower(filter.Extension)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		query = query.Where("extension = ?", ext)
+	}
+
+	if filter.MimeType != "" {
+		if strings.HasSuffix(filter.MimeType, "/*") {
+			query = query.Where("mime_type LIKE ?", escapeLike(strings.TrimSuffix(filter.MimeType, "*"))+"%")
+		} else {
+			query = query.Where("mime_type = ?", filter.MimeType)
+		}
+	}
+
+	return filterMedia(query, filter.Media)
+}
+
+// parsePagination reads limit/offset query parameters with the API defaults
+func parsePagination(c *gin.Context) (int, int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// formatFile builds the public JSON representation of a file record
+func formatFile(file models.File) gin.H {
+	return gin.H{
+		"id":           file.ID,
+		"name":         file.OriginalName,
+		"size":         file.Size,
+		"mime_type":    file.MimeType,
+		"extension":    file.Extension,
+		"hash":         file.Hash,
+		"folder_id":    file.FolderID,
+		"virtual_path": file.VirtualPath,
+		"version":      file.CurrentVersion,
+		"metadata":     file.Metadata,
+		"tags":         file.Tags,
+		"visibility":   file.Visibility,
+		"revision":     file.Revision,
+		"media":        file.MediaInfo,
+		"media_status": file.MediaStatus,
+		"has_original": file.OriginalPath != "",
+		"uploaded_at":  file.UploadedAt,
+		"download_url": fmt.Sprintf("/api/v1/files/%d/download", file.ID),
+	}
 }
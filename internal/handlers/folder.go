@@ -0,0 +1,788 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/database"
+	"api-file-upload-go/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// CreateFolderRequest is the body accepted by CreateFolder
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+// RenameFolderRequest is the body accepted by RenameFolder
+type RenameFolderRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// MoveFolderRequest is the body accepted by MoveFolder. A null parent_id moves
+// the folder to the root.
+type MoveFolderRequest struct {
+	ParentID *uint `json:"parent_id"`
+}
+
+// CreateFolder handles folder creation
+func (h *FileHandler) CreateFolder(c *gin.Context) {
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := validateFolderName(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	parentPath := ""
+	if req.ParentID != nil {
+		parent, ok := h.loadFolder(c, strconv.FormatUint(uint64(*req.ParentID), 10))
+		if !ok {
+			return
+		}
+		parentPath = parent.Path
+	}
+
+	folder := models.Folder{
+		Name:     name,
+		ParentID: req.ParentID,
+		Path:     joinVirtualPath(parentPath, name),
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := h.nameTakenInFolder(tx, req.ParentID, name, 0, 0)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errNameTaken
+		}
+		return tx.Create(&folder).Error
+	})
+	if err != nil {
+		h.respondFolderError(c, err, name, "Failed to create folder")
+		return
+	}
+
+	h.log(c).Infof("Folder created successfully: %s (ID: %d)", folder.Path, folder.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Folder created successfully",
+		"data":    formatFolder(folder),
+	})
+}
+
+// ListFolders handles listing root-level folders
+func (h *FileHandler) ListFolders(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
+	query := h.db.Model(&models.Folder{}).Where("parent_id IS NULL")
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count folders:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to count folders",
+		})
+		return
+	}
+
+	var folders []models.Folder
+	if err := query.Limit(limit).Offset(offset).Order("name ASC").Find(&folders).Error; err != nil {
+		h.log(c).Error("Failed to list folders:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list folders",
+		})
+		return
+	}
+
+	folderList := []gin.H{}
+	for _, folder := range folders {
+		folderList = append(folderList, formatFolder(folder))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folders": folderList,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
+
+// GetFolder handles getting folder details by ID
+func (h *FileHandler) GetFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    formatFolder(*folder),
+	})
+}
+
+// ListFolderChildren handles listing a folder's subfolders and files. Files
+// are paginated the same way as ListFiles; subfolders are always returned in full.
+func (h *FileHandler) ListFolderChildren(c *gin.Context) {
+	folder, ok := h.loadFolder(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	limit, offset := parsePagination(c)
+
+	var subfolders []models.Folder
+	if err := h.db.Where("parent_id = ?", folder.ID).Order("name ASC").Find(&subfolders).Error; err != nil {
+		h.log(c).Error("Failed to list subfolders:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list subfolders",
+		})
+		return
+	}
+
+	var total int64
+	if err := h.db.Model(&models.File{}).Where("folder_id = ?", folder.ID).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count files:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to count files",
+		})
+		return
+	}
+
+	var files []models.File
+	if err := h.db.Where("folder_id = ?", folder.ID).
+		Limit(limit).Offset(offset).
+		Order("uploaded_at DESC").
+		Find(&files).Error; err != nil {
+		h.log(c).Error("Failed to list files:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list files",
+		})
+		return
+	}
+
+	folderList := []gin.H{}
+	for _, subfolder := range subfolders {
+		folderList = append(folderList, formatFolder(subfolder))
+	}
+
+	fileList := []gin.H{}
+	for _, file := range files {
+		fileList = append(fileList, formatFile(file))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folder":  formatFolder(*folder),
+			"folders": folderList,
+			"files":   fileList,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
+
+// RenameFolder handles renaming a folder
+func (h *FileHandler) RenameFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req RenameFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := validateFolderName(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	parentPath := parentVirtualPath(folder.Path)
+	if err := h.relocateFolder(folder, folder.ParentID, parentPath, name); err != nil {
+		h.respondFolderError(c, err, name, "Failed to rename folder")
+		return
+	}
+
+	h.log(c).Infof("Folder renamed successfully: %s (ID: %d)", folder.Path, folder.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder renamed successfully",
+		"data":    formatFolder(*folder),
+	})
+}
+
+// MoveFolder handles moving a folder under a new parent (or to the root)
+func (h *FileHandler) MoveFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req MoveFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	parentPath := ""
+	if req.ParentID != nil {
+		parent, ok := h.loadFolder(c, strconv.FormatUint(uint64(*req.ParentID), 10))
+		if !ok {
+			return
+		}
+
+		// A folder cannot be moved into itself or one of its descendants
+		if parent.ID == folder.ID || strings.HasPrefix(parent.Path, folder.Path+"/") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": "Cannot move a folder into itself or one of its subfolders",
+			})
+			return
+		}
+		parentPath = parent.Path
+	}
+
+	if err := h.relocateFolder(folder, req.ParentID, parentPath, folder.Name); err != nil {
+		h.respondFolderError(c, err, folder.Name, "Failed to move folder")
+		return
+	}
+
+	h.log(c).Infof("Folder moved successfully: %s (ID: %d)", folder.Path, folder.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder moved successfully",
+		"data":    formatFolder(*folder),
+	})
+}
+
+// DeleteFolder handles recursive folder deletion. The folder, its subfolders
+// and their files are moved to the trash (soft delete) and can be restored;
+// file contents are kept on disk.
+func (h *FileHandler) DeleteFolder(c *gin.Context) {
+	folder, ok := h.loadFolder(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	// Use a single timestamp for the whole subtree so it can be restored as a unit
+	deletedAt := time.Now().Truncate(time.Microsecond)
+
+	var folderCount, fileCount int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		ids, err := subtreeFolderIDs(tx, folder)
+		if err != nil {
+			return err
+		}
+
+		var files []models.File
+		if err := tx.Where("folder_id IN ?", ids).Find(&files).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.File{}).Where("folder_id IN ?", ids).Update("deleted_at", deletedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		fileCount = result.RowsAffected
+		if err := h.enqueueFileEvents(tx, c, models.EventFileDeleted, files...); err != nil {
+			return err
+		}
+
+		result = tx.Model(&models.Folder{}).Where("id IN ?", ids).Update("deleted_at", deletedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		folderCount = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		h.log(c).Error("Failed to delete folder:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to delete folder",
+		})
+		return
+	}
+
+	h.notifyEvents()
+	h.log(c).Infof("Folder moved to trash: %s (ID: %d, %d folders, %d files)", folder.Path, folder.ID, folderCount, fileCount)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder moved to trash",
+		"data": gin.H{
+			"folders": folderCount,
+			"files":   fileCount,
+		},
+	})
+}
+
+// RestoreFolder handles restoring a trashed folder together with everything
+// that was trashed with it
+func (h *FileHandler) RestoreFolder(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid folder ID",
+		})
+		return
+	}
+
+	var folder models.Folder
+	if err := h.db.Unscoped().Where("deleted_at IS NOT NULL").First(&folder, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "Folder not found in trash",
+			})
+			return
+		}
+		h.log(c).Error("Failed to get folder:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get folder",
+		})
+		return
+	}
+
+	// The parent must still be live; restore it first otherwise
+	if folder.ParentID != nil {
+		var parent models.Folder
+		if err := h.db.First(&parent, *folder.ParentID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":   true,
+					"message": "Parent folder is in the trash, restore it first",
+				})
+				return
+			}
+			h.log(c).Error("Failed to get parent folder:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   true,
+				"message": "Failed to get parent folder",
+			})
+			return
+		}
+	}
+
+	deletedAt := folder.DeletedAt.Time
+	var folderCount, fileCount int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := h.nameTakenInFolder(tx, folder.ParentID, folder.Name, folder.ID, 0)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errNameTaken
+		}
+
+		var ids []uint
+		if err := tx.Unscoped().Model(&models.Folder{}).
+			Where("deleted_at = ?", deletedAt).
+			Where("id = ? OR path LIKE ?", folder.ID, escapeLike(folder.Path)+"/%").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		var files []models.File
+		if err := tx.Unscoped().Where("deleted_at = ? AND folder_id IN ?", deletedAt, ids).Find(&files).Error; err != nil {
+			return err
+		}
+
+		// The same content may have been uploaded again meanwhile
+		for i := range files {
+			if err := duplicateContent(tx, files[i].Hash, files[i].ID); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Unscoped().Model(&models.File{}).
+			Where("deleted_at = ? AND folder_id IN ?", deletedAt, ids).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		fileCount = result.RowsAffected
+		for i := range files {
+			files[i].DeletedAt = gorm.DeletedAt{}
+		}
+		if err := h.enqueueFileEvents(tx, c, models.EventFileRestored, files...); err != nil {
+			return err
+		}
+
+		result = tx.Unscoped().Model(&models.Folder{}).Where("id IN ?", ids).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		folderCount = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		h.respondFolderError(c, err, folder.Name, "Failed to restore folder")
+		return
+	}
+
+	h.notifyEvents()
+	h.log(c).Infof("Folder restored from trash: %s (ID: %d, %d folders, %d files)", folder.Path, folder.ID, folderCount, fileCount)
+
+	folder.DeletedAt = gorm.DeletedAt{}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Folder restored successfully",
+		"data": gin.H{
+			"folder":  formatFolder(folder),
+			"folders": folderCount,
+			"files":   fileCount,
+		},
+	})
+}
+
+// ListTrash handles listing trashed folders and files
+func (h *FileHandler) ListTrash(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
+	var folders []models.Folder
+	if err := h.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&folders).Error; err != nil {
+		h.log(c).Error("Failed to list trashed folders:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list trashed folders",
+		})
+		return
+	}
+
+	query := h.db.Unscoped().Model(&models.File{}).Where("deleted_at IS NOT NULL")
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count trashed files:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to count trashed files",
+		})
+		return
+	}
+
+	var files []models.File
+	if err := query.Limit(limit).Offset(offset).Order("deleted_at DESC").Find(&files).Error; err != nil {
+		h.log(c).Error("Failed to list trashed files:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list trashed files",
+		})
+		return
+	}
+
+	folderList := []gin.H{}
+	for _, folder := range folders {
+		entry := formatFolder(folder)
+		entry["deleted_at"] = folder.DeletedAt.Time
+		folderList = append(folderList, entry)
+	}
+
+	fileList := []gin.H{}
+	for _, file := range files {
+		entry := formatFile(file)
+		entry["deleted_at"] = file.DeletedAt.Time
+		fileList = append(fileList, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"folders": folderList,
+			"files":   fileList,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
+
+// errNameTaken is returned inside folder transactions when a sibling already
+// uses the requested name
+var errNameTaken = errors.New("name already taken")
+
+// isNameConflict reports whether err is a violation of the unique name
+// indexes, hit when concurrent requests both pass the name check
+func isNameConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+		(pgErr.ConstraintName == database.FolderNameIndex || pgErr.ConstraintName == database.FileNameIndex)
+}
+
+// isContentConflict reports whether err is a violation of the unique hash
+// index on live files, hit when concurrent requests store the same content
+func isContentConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == database.FileHashIndex
+}
+
+// loadFolder parses a folder ID and loads the live folder, writing the error
+// response itself when it fails
+func (h *FileHandler) loadFolder(c *gin.Context, idStr string) (*models.Folder, bool) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid folder ID",
+		})
+		return nil, false
+	}
+
+	var folder models.Folder
+	if err := h.db.First(&folder, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "Folder not found",
+			})
+			return nil, false
+		}
+		h.log(c).Error("Failed to get folder:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get folder",
+		})
+		return nil, false
+	}
+
+	return &folder, true
+}
+
+// nameTakenInFolder reports whether a folder or file named name already lives
+// directly under parentID (nil for the root). excludeFolderID/excludeFileID
+// skip the item being renamed/moved.
+func (h *FileHandler) nameTakenInFolder(tx *gorm.DB, parentID *uint, name string, excludeFolderID, excludeFileID uint) (bool, error) {
+	folders := tx.Model(&models.Folder{}).Where("name = ? AND id <> ?", name, excludeFolderID)
+	if parentID == nil {
+		folders = folders.Where("parent_id IS NULL")
+	} else {
+		folders = folders.Where("parent_id = ?", *parentID)
+	}
+
+	var count int64
+	if err := folders.Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	files := tx.Model(&models.File{}).Where("original_name = ? AND id <> ?", name, excludeFileID)
+	if parentID == nil {
+		files = files.Where("folder_id IS NULL")
+	} else {
+		files = files.Where("folder_id = ?", *parentID)
+	}
+	if err := files.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// relocateFolder renames and/or re-parents a folder, rewriting the virtual
+// paths of every descendant folder and file in the same transaction. Those in
+// the trash are rewritten too, so they are restored at the new path.
+func (h *FileHandler) relocateFolder(folder *models.Folder, parentID *uint, parentPath, name string) error {
+	oldPath := folder.Path
+	newPath := joinVirtualPath(parentPath, name)
+	prefixLen := utf8.RuneCountInString(oldPath) + 1
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := h.nameTakenInFolder(tx, parentID, name, folder.ID, 0)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errNameTaken
+		}
+
+		if err := tx.Model(folder).Updates(map[string]interface{}{
+			"name":      name,
+			"parent_id": parentID,
+			"path":      newPath,
+		}).Error; err != nil {
+			return err
+		}
+
+		if oldPath == newPath {
+			return nil
+		}
+
+		// Descendants are found by parent rather than by path, which a
+		// trashed folder of the same name may share
+		ids, err := descendantFolderIDs(tx, folder.ID)
+		if err != nil {
+			return err
+		}
+		descendants := escapeLike(oldPath) + "/%"
+		if len(ids) > 0 {
+			if err := tx.Unscoped().Model(&models.Folder{}).
+				Where("id IN ? AND path LIKE ?", ids, descendants).
+				Update("path", gorm.Expr("? || SUBSTR(path, ?)", newPath, prefixLen)).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Model(&models.File{}).
+			Where("folder_id IN ? AND virtual_path LIKE ?", append(ids, folder.ID), descendants).
+			Update("virtual_path", gorm.Expr("? || SUBSTR(virtual_path, ?)", newPath, prefixLen)).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	folder.Name = name
+	folder.ParentID = parentID
+	folder.Path = newPath
+	return nil
+}
+
+// respondFolderError maps folder transaction errors to API responses
+func (h *FileHandler) respondFolderError(c *gin.Context, err error, name, message string) {
+	var itemErr *itemError
+	if errors.As(err, &itemErr) {
+		h.respondItemError(c, err, message)
+		return
+	}
+	if isContentConflict(err) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   true,
+			"message": "A file in this folder has the same content as another file",
+		})
+		return
+	}
+	if err == errNameTaken || isNameConflict(err) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("An item named %s already exists in this folder", name),
+		})
+		return
+	}
+
+	h.log(c).Error(message+":", err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   true,
+		"message": message,
+	})
+}
+
+// subtreeFolderIDs returns the IDs of folder and all of its live descendants
+func subtreeFolderIDs(tx *gorm.DB, folder *models.Folder) ([]uint, error) {
+	var ids []uint
+	if err := tx.Model(&models.Folder{}).
+		Where("path LIKE ?", escapeLike(folder.Path)+"/%").
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return append(ids, folder.ID), nil
+}
+
+// descendantFolderIDs returns the IDs of every folder below folderID, those
+// in the trash included
+func descendantFolderIDs(tx *gorm.DB, folderID uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Raw(`WITH RECURSIVE subtree AS (
+		SELECT id FROM folders WHERE parent_id = ?
+		UNION ALL
+		SELECT folders.id FROM folders JOIN subtree ON folders.parent_id = subtree.id
+	) SELECT id FROM subtree`, folderID).Scan(&ids).Error
+	return ids, err
+}
+
+// validateFolderName checks that name can be used as a single path segment
+func validateFolderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Folder name is required")
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("Invalid folder name: %s", name)
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("Folder name cannot contain slashes")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("Folder name is too long")
+	}
+	return nil
+}
+
+// joinVirtualPath appends name to a parent virtual path ("" or "/" is the root)
+func joinVirtualPath(parentPath, name string) string {
+	return strings.TrimSuffix(parentPath, "/") + "/" + name
+}
+
+// parentVirtualPath returns the virtual path of the parent of p
+func parentVirtualPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i > 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// escapeLike escapes LIKE wildcards so user-provided names match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// formatFolder builds the public JSON representation of a folder
+func formatFolder(folder models.Folder) gin.H {
+	return gin.H{
+		"id":           folder.ID,
+		"name":         folder.Name,
+		"parent_id":    folder.ParentID,
+		"path":         folder.Path,
+		"created_at":   folder.CreatedAt,
+		"updated_at":   folder.UpdatedAt,
+		"children_url": fmt.Sprintf("/api/v1/folders/%d/children", folder.ID),
+	}
+}
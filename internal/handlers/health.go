@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/buildinfo"
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/database"
+	"api-file-upload-go/internal/health"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Health check names, as used in HEALTH_CHECK_TIMEOUTS
+const (
+	checkDatabase   = "database"
+	checkStorage    = "storage"
+	checkMigrations = "migrations"
+	checkScanner    = "scanner"
+)
+
+// newHealthChecker registers the dependency checks. The database, storage
+// and migrations are critical; the scanner, checked only when SCANNER_ADDR
+// is set, just degrades the health report.
+func newHealthChecker(cfg *config.Config, db *gorm.DB) *health.Checker {
+	timeout := func(name string) time.Duration {
+		if t, ok := cfg.HealthCheckTimeouts[name]; ok {
+			return t
+		}
+		return cfg.HealthCheckTimeout
+	}
+
+	checker := health.NewChecker(cfg.HealthCacheTTL)
+	checker.Register(health.Check{
+		Name:     checkDatabase,
+		Critical: true,
+		Timeout:  timeout(checkDatabase),
+		Run:      health.Database(db),
+	})
+	checker.Register(health.Check{
+		Name:     checkStorage,
+		Critical: true,
+		Timeout:  timeout(checkStorage),
+		Run:      health.Storage(cfg.UploadDir, cfg.HealthMinFreeBytes),
+	})
+	checker.Register(health.Check{
+		Name:     checkMigrations,
+		Critical: true,
+		Timeout:  timeout(checkMigrations),
+		Run: func(ctx context.Context) (map[string]interface{}, error) {
+			pending, err := database.PendingMigrations(db.WithContext(ctx))
+			if err != nil {
+				return nil, err
+			}
+			if len(pending) > 0 {
+				return nil, fmt.Errorf("missing: %s", strings.Join(pending, ", "))
+			}
+			return map[string]interface{}{"applied": true}, nil
+		},
+	})
+	if cfg.ScannerAddr != "" {
+		checker.Register(health.Check{
+			Name:    checkScanner,
+			Timeout: timeout(checkScanner),
+			Run:     health.TCP(cfg.ScannerAddr),
+		})
+	}
+	return checker
+}
+
+// Livez handles the liveness probe: the process is up and serving requests.
+// It doesn't touch any dependency, so a database outage doesn't get the
+// container restarted.
+func (h *FileHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// Readyz handles the readiness probe: the critical checks pass and the server
+// isn't shutting down
+func (h *FileHandler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "draining",
+			"message": "Server is shutting down",
+		})
+		return
+	}
+
+	checks := h.health.Run(c.Request.Context(), true)
+	if !health.Healthy(checks) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "not_ready",
+			"checks": checks,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ready",
+	})
+}
+
+// HealthCheck handles the detailed health report: every check with its
+// latency and details, plus build info. It answers 503 when a critical check
+// fails or the server is shutting down, and reports "degraded" when only a
+// non-critical one does.
+func (h *FileHandler) HealthCheck(c *gin.Context) {
+	checks := h.health.Run(c.Request.Context(), false)
+
+	status, message, code := "ok", "API is healthy", http.StatusOK
+	for _, check := range checks {
+		if check.Status != health.StatusUp {
+			status, message = "degraded", "Some optional dependencies are unavailable"
+		}
+	}
+	if !health.Healthy(checks) {
+		status, message, code = "error", "Critical dependencies are unavailable", http.StatusServiceUnavailable
+	}
+	if h.draining.Load() {
+		status, message, code = "draining", "Server is shutting down", http.StatusServiceUnavailable
+	}
+
+	build := buildinfo.Get()
+	c.JSON(code, gin.H{
+		"status":         status,
+		"message":        message,
+		"version":        build.Version,
+		"build":          build,
+		"uptime_seconds": int64(time.Since(h.started).Seconds()),
+		"timestamp":      time.Now().Format(time.RFC3339),
+		"checks":         checks,
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/metrics"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rawBodyKey keeps the request body before any size limit, so route
+// middlewares can replace the default limit instead of nesting under it
+const rawBodyKey = "raw_body"
+
+// multipartOverhead is the room left for multipart headers and boundaries
+// on top of the file size limit
+const multipartOverhead = 1 << 20
+
+// LimitRequests is the middleware applying the default request limits: the
+// body is capped at MAX_BODY_SIZE, and the body must be read and the
+// response written within REQUEST_TIMEOUT, so a slow client can't tie up a
+// connection. Routes streaming large bodies replace these limits with
+// UploadLimits, DownloadLimits or StreamLimits.
+func (h *FileHandler) LimitRequests(c *gin.Context) {
+	cfg := h.requestConfig(c)
+	h.setDeadline(c, cfg.RequestTimeout)
+	// Multipart bodies are left to UploadLimits and ContentLimits, which run
+	// later and may allow more
+	if c.ContentType() != "multipart/form-data" && bodyTooLarge(c, cfg.MaxBodySize) {
+		return
+	}
+	limitBody(c, cfg.MaxBodySize)
+	c.Next()
+}
+
+// UploadLimits lifts the deadline of multi-file uploads, which are guarded
+// by the idle timeout and minimum rate instead, and caps the body at
+// MAX_REQUEST_SIZE
+func (h *FileHandler) UploadLimits(c *gin.Context) {
+	h.setDeadline(c, 0)
+	maxSize := h.requestConfig(c).MaxRequestSize
+	if bodyTooLarge(c, maxSize) {
+		metrics.UploadRejected(metrics.RejectRequestTooLarge)
+		return
+	}
+	limitBody(c, maxSize)
+	c.Next()
+}
+
+// ContentLimits is UploadLimits for single-file uploads, capping the body at
+// MAX_FILE_SIZE plus the multipart overhead
+func (h *FileHandler) ContentLimits(c *gin.Context) {
+	h.setDeadline(c, 0)
+	limit := int64(0)
+	if maxSize := h.requestConfig(c).MaxFileSize; maxSize > 0 {
+		limit = maxSize + multipartOverhead
+	}
+	if bodyTooLarge(c, limit) {
+		metrics.UploadRejected(metrics.RejectRequestTooLarge)
+		return
+	}
+	limitBody(c, limit)
+	c.Next()
+}
+
+// DownloadLimits gives downloads and archives DOWNLOAD_TIMEOUT to be
+// written instead of REQUEST_TIMEOUT
+func (h *FileHandler) DownloadLimits(c *gin.Context) {
+	h.setDeadline(c, h.requestConfig(c).DownloadTimeout)
+	c.Next()
+}
+
+// StreamLimits lifts the deadline of event streams, which stay open until
+// the client leaves
+func (h *FileHandler) StreamLimits(c *gin.Context) {
+	h.setDeadline(c, 0)
+	c.Next()
+}
+
+// setDeadline sets the connection's read and write deadlines timeout from
+// now, or clears them with timeout 0. net/http resets them for the next
+// request on the connection.
+func (h *FileHandler) setDeadline(c *gin.Context, timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	controller := http.NewResponseController(c.Writer)
+	if err := controller.SetReadDeadline(deadline); err != nil {
+		h.log(c).Debug("Failed to set read deadline:", err)
+	}
+	if err := controller.SetWriteDeadline(deadline); err != nil {
+		h.log(c).Debug("Failed to set write deadline:", err)
+	}
+}
+
+// bodyTooLarge refuses with 413 a request declaring a Content-Length over
+// limit (0 = unlimited), without reading the body
+func bodyTooLarge(c *gin.Context, limit int64) bool {
+	if limit <= 0 || c.Request.ContentLength <= limit {
+		return false
+	}
+	c.Header("Connection", "close")
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":   true,
+		"message": fmt.Sprintf("Request body too large: maximum %d bytes", limit),
+	})
+	return true
+}
+
+// limitBody caps the request body at limit bytes (0 = unlimited), replacing
+// any previous limit. Reads past it fail with *http.MaxBytesError.
+func limitBody(c *gin.Context, limit int64) {
+	raw, ok := c.Get(rawBodyKey)
+	if !ok {
+		raw = c.Request.Body
+		c.Set(rawBodyKey, raw)
+	}
+	body := raw.(io.ReadCloser)
+
+	c.Request.Body = body
+	if limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, body, limit)
+	}
+}
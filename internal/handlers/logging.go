@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/logger"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// requestIDPattern restricts the incoming X-Request-ID values that are kept
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// LogRequests is the middleware giving every request an ID and writing one
+// access log line when it completes. A valid incoming X-Request-ID header is
+// kept, otherwise an ID is generated; either way it is echoed in the
+// X-Request-ID response header. Entries logged with h.log(c) carry the same
+// request ID, route, tenant and file ID. Successful requests to routes listed
+// in LogSampleRates are only logged at that rate. It is the first middleware,
+// so it also takes the configuration snapshot the request runs with.
+func (h *FileHandler) LogRequests(c *gin.Context) {
+	cfg := h.requestConfig(c)
+	requestID := c.GetHeader("X-Request-ID")
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = newRandomID()
+	}
+	c.Header("X-Request-ID", requestID)
+
+	route := c.FullPath()
+	fields := logrus.Fields{
+		"request_id": requestID,
+		"method":     c.Request.Method,
+		"route":      route,
+		"tenant":     requestTenant(c),
+	}
+	if id := c.Param("id"); id != "" && strings.HasPrefix(route, "/api/v1/files/") {
+		fields["file_id"] = id
+	}
+	c.Request = c.Request.WithContext(logger.WithRequest(c.Request.Context(), fields))
+
+	c.Next()
+
+	status := c.Writer.Status()
+	if rate, ok := cfg.LogSampleRates[route]; ok && status < http.StatusBadRequest && rand.Float64() >= rate {
+		return
+	}
+
+	entry := h.log(c).WithFields(logrus.Fields{
+		"status":     status,
+		"path":       c.Request.URL.Path,
+		"bytes":      max(c.Writer.Size(), 0),
+		"client_ip":  c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+	})
+	if len(c.Errors) > 0 {
+		entry = entry.WithField("errors", c.Errors.String())
+	}
+
+	message := fmt.Sprintf("%s %s %d", c.Request.Method, c.Request.URL.Path, status)
+	switch {
+	case status >= http.StatusInternalServerError:
+		entry.Error(message)
+	case status >= http.StatusBadRequest:
+		entry.Warn(message)
+	default:
+		entry.Info(message)
+	}
+}
+
+// Recovery returns the middleware turning panics into 500 responses, logged
+// with the request's fields and the stack trace
+func (h *FileHandler) Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		h.log(c).WithFields(logrus.Fields{
+			"panic": fmt.Sprint(recovered),
+			"stack": string(debug.Stack()),
+		}).Error("Recovered from panic")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Internal server error",
+		})
+	})
+}
+
+// log returns the logger for the request handled by c. Its entries carry the
+// request ID, route, tenant, file ID, latency and trace IDs.
+func (h *FileHandler) log(c *gin.Context) *logrus.Entry {
+	return h.logger.WithContext(c.Request.Context())
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/media"
+	"api-file-upload-go/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// mediaRangeFilters maps the numeric media filters of ListFiles to the
+// media_info key and comparison they apply
+var mediaRangeFilters = map[string]struct{ key, op string }{
+	"min_width":    {"width", ">="},
+	"max_width":    {"width", "<="},
+	"min_height":   {"height", ">="},
+	"max_height":   {"height", "<="},
+	"min_pages":    {"pages", ">="},
+	"max_pages":    {"pages", "<="},
+	"min_duration": {"duration", ">="},
+	"max_duration": {"duration", "<="},
+}
+
+// mediaFilterParams lists every media filter accepted by ListFiles
+var mediaFilterParams = []string{
+	"min_width", "max_width", "min_height", "max_height",
+	"min_pages", "max_pages", "min_duration", "max_duration",
+	"taken_after", "taken_before", "camera",
+}
+
+// extractMedia runs the media extractors registered for file's type. Cheap
+// extractors run before returning, so their results are part of the upload
+// response; the ones that read the whole file run in the background and
+// update the record when they finish.
+func (h *FileHandler) extractMedia(file *models.File) {
+	inline, background := media.Extractors(file.MimeType)
+	if len(inline) == 0 && len(background) == 0 {
+		// Clear what was extracted from previous content
+		if file.MediaInfo != nil || file.MediaStatus != "" {
+			h.saveMediaInfo(file, nil, "")
+		}
+		return
+	}
+
+	info := &models.MediaInfo{}
+	failed := h.runExtractors(inline, file, info)
+
+	status := models.MediaStatusReady
+	switch {
+	case len(background) > 0:
+		status = models.MediaStatusPending
+	case failed:
+		status = models.MediaStatusFailed
+	}
+	h.saveMediaInfo(file, info, status)
+
+	if len(background) == 0 {
+		return
+	}
+
+	// Work on copies; the caller keeps using file and info for its response
+	source := *file
+	result := *info
+	go func() {
+		h.mediaSlots <- struct{}{}
+		defer func() { <-h.mediaSlots }()
+
+		status := models.MediaStatusReady
+		if h.runExtractors(background, &source, &result) || failed {
+			status = models.MediaStatusFailed
+		}
+		h.saveMediaInfo(&source, &result, status)
+	}()
+}
+
+// runExtractors applies extractors to file's content, logging failures, and
+// reports whether any of them failed
+func (h *FileHandler) runExtractors(extractors []media.Extractor, file *models.File, info *models.MediaInfo) bool {
+	errs := media.Run(extractors, file.Path, info)
+	for _, err := range errs {
+		h.logger.Warnf("Failed to extract media metadata from %s (ID: %d): %v", file.OriginalName, file.ID, err)
+	}
+	return len(errs) > 0
+}
+
+// saveMediaInfo stores extraction results. The update only applies while the
+// file still has the content that was analysed, so a slow background run
+// can't overwrite the results for newer content.
+func (h *FileHandler) saveMediaInfo(file *models.File, info *models.MediaInfo, status string) {
+	result := h.db.Model(&models.File{}).
+		Where("id = ? AND hash = ?", file.ID, file.Hash).
+		Select("media_info", "media_status").
+		Updates(&models.File{MediaInfo: info, MediaStatus: status})
+	if result.Error != nil {
+		h.logger.Warn("Failed to save media metadata:", result.Error)
+		return
+	}
+	file.MediaInfo = info
+	file.MediaStatus = status
+}
+
+// filterMedia applies the media filters (see mediaFilterParams) to a files
+// query. Dates are compared as camera local time.
+func filterMedia(query *gorm.DB, filters map[string]string) (*gorm.DB, error) {
+	for param, value := range filters {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if filter, ok := mediaRangeFilters[param]; ok {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: must be a number", param)
+			}
+			query = query.Where(fmt.Sprintf("CAST(media_info->>'%s' AS NUMERIC) %s ?", filter.key, filter.op), number)
+			continue
+		}
+
+		switch param {
+		case "taken_after", "taken_before":
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				if _, err := time.Parse("2006-01-02T15:04:05", value); err != nil {
+					return nil, fmt.Errorf("Invalid %s: use YYYY-MM-DD or YYYY-MM-DDTHH:MM:SS", param)
+				}
+			}
+			if param == "taken_after" {
+				query = query.Where("media_info->>'taken_at' >= ?", value)
+			} else {
+				query = query.Where("media_info->>'taken_at' < ?", value)
+			}
+		case "camera":
+			query = query.Where("(COALESCE(media_info->>'camera_make', '') || ' ' || COALESCE(media_info->>'camera_model', '')) ILIKE ?", "%"+escapeLike(value)+"%")
+		default:
+			return nil, fmt.Errorf("Unknown media filter: %s", param)
+		}
+	}
+	return query, nil
+}
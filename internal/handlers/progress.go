@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/progress"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadIDPattern restricts client-supplied upload IDs
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// drainRetryAfter is the Retry-After (seconds) of uploads refused during
+// shutdown, by which time another instance should be taking them
+const drainRetryAfter = "5"
+
+// TrackUploadProgress is the middleware registering upload requests in the
+// progress registry. Clients choose the ID with the X-Upload-ID header (or
+// ?upload_id=) to poll GetUploadProgress while the request is running;
+// otherwise one is generated. IDs are per tenant, so another tenant using
+// the same one doesn't conflict. The ID is echoed in the X-Upload-ID response
+// header.
+func (h *FileHandler) TrackUploadProgress(c *gin.Context) {
+	if h.draining.Load() {
+		c.Header("Retry-After", drainRetryAfter)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":   true,
+			"message": "Server is shutting down, retry the upload",
+		})
+		return
+	}
+
+	id := strings.TrimSpace(c.GetHeader("X-Upload-ID"))
+	if id == "" {
+		id = c.Query("upload_id")
+	}
+	if id == "" {
+		id = newRandomID()
+	}
+	if !uploadIDPattern.MatchString(id) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid upload ID: use up to 128 letters, digits, dots, dashes or underscores",
+		})
+		return
+	}
+
+	// A stalled upload is cancelled by expiring the connection's read
+	// deadline, which fails the handler's pending body read
+	controller := http.NewResponseController(c.Writer)
+	log := h.log(c)
+	tracker, err := h.uploads.Begin(progress.Upload{
+		ID:            id,
+		TenantID:      requestTenant(c),
+		Client:        requestActor(c),
+		Path:          c.FullPath(),
+		ExpectedBytes: c.Request.ContentLength,
+	}, func() {
+		if err := controller.SetReadDeadline(time.Now()); err != nil {
+			log.Warnf("Failed to cancel stalled upload %s: %v", id, err)
+		}
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error":   true,
+			"message": "Upload ID already in use",
+		})
+		return
+	}
+
+	c.Header("X-Upload-ID", id)
+	c.Request.Body = tracker.Wrap(c.Request.Body)
+
+	c.Next()
+
+	tracker.Finish(c.Writer.Status())
+	snapshot := tracker.Snapshot()
+	metrics.ObserveUpload(c.FullPath(), snapshot.BytesReceived, snapshot.FinishedAt.Sub(snapshot.StartedAt))
+}
+
+// GetUploadProgress handles GET /api/v1/uploads/:id/progress. Uploads stay
+// visible for a minute after they finish so clients can see the outcome.
+func (h *FileHandler) GetUploadProgress(c *gin.Context) {
+	snapshot, ok := h.uploads.Get(requestTenant(c), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   true,
+			"message": "Upload not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    snapshot,
+	})
+}
+
+// ListUploads handles listing every in-flight and recently finished upload,
+// across tenants. It requires the admin token.
+func (h *FileHandler) ListUploads(c *gin.Context) {
+	if !h.isPrivileged(c) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   true,
+			"message": "Only administrators can list uploads",
+		})
+		return
+	}
+
+	uploads := h.uploads.List()
+	active := 0
+	for _, upload := range uploads {
+		if upload.FinishedAt == nil {
+			active++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"uploads": uploads,
+			"active":  active,
+			"total":   len(uploads),
+		},
+	})
+}
+
+// cancelledUploadError is the response for an upload cancelled by the
+// registry, after stalling, sending too slowly or because the server is
+// shutting down
+func (h *FileHandler) cancelledUploadError(cfg *config.Config, err error) *itemError {
+	if errors.Is(err, progress.ErrShutdown) {
+		metrics.UploadRejected(metrics.RejectShutdown)
+		return &itemError{
+			status:  http.StatusServiceUnavailable,
+			message: "Server is shutting down, retry the upload",
+		}
+	}
+	if errors.Is(err, progress.ErrTooSlow) {
+		metrics.UploadRejected(metrics.RejectTooSlow)
+		return &itemError{
+			status:  http.StatusRequestTimeout,
+			message: fmt.Sprintf("Upload too slow: less than %d bytes/s over %s", cfg.UploadMinRate, cfg.UploadMinRateWindow),
+		}
+	}
+	metrics.UploadRejected(metrics.RejectStalled)
+	return &itemError{
+		status:  http.StatusRequestTimeout,
+		message: fmt.Sprintf("Upload stalled: no data received for %s", cfg.UploadIdleTimeout),
+	}
+}
+
+// newRandomID returns a random 128-bit ID in hex
+func newRandomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/ratelimit"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitClass returns the class a route is limited under, or "" for
+// probes and metrics, which are never limited
+func rateLimitClass(c *gin.Context) string {
+	switch c.FullPath() {
+	case "/health", "/livez", "/readyz", "/metrics":
+		return ""
+	case "/api/v1/files/upload", "/api/v1/files/:id/content":
+		return ratelimit.ClassUpload
+	case "/api/v1/files/:id/download", "/api/v1/files/archive":
+		return ratelimit.ClassDownload
+	default:
+		return ratelimit.ClassMetadata
+	}
+}
+
+// rateLimitKeys lists the principals a request is counted against: always
+// the client IP and, with RATE_LIMIT_KEY=auto, also the API key (X-API-Key
+// or bearer token, hashed) or else the user (X-User-ID within the tenant).
+// Those headers aren't verified, so a client sending a new value on every
+// request still runs into the limits of its IP.
+func (h *FileHandler) rateLimitKeys(c *gin.Context) []string {
+	keys := []string{"ip:" + c.ClientIP()}
+	if h.requestConfig(c).RateLimitKey != "auto" {
+		return keys
+	}
+	apiKey := strings.TrimSpace(c.GetHeader("X-API-Key"))
+	if apiKey == "" {
+		apiKey = strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	}
+	if apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return append(keys, "key:"+hex.EncodeToString(sum[:16]))
+	}
+	if user := strings.TrimSpace(c.GetHeader("X-User-ID")); user != "" {
+		return append(keys, "user:"+requestTenant(c)+"/"+user)
+	}
+	return keys
+}
+
+// RateLimit is the middleware applying the rate limits: a token bucket per
+// principal and request class, reported in RateLimit-* headers, a cap on
+// concurrent uploads and a daily bandwidth quota. A request counted against
+// several principals must be within the limits of each, and the headers
+// report the bucket closest to empty. Refused requests get 429 with
+// Retry-After. Preflights and requests with the admin token aren't limited.
+// If the limit store fails, requests are let through.
+func (h *FileHandler) RateLimit(c *gin.Context) {
+	class := rateLimitClass(c)
+	if class == "" || c.Request.Method == http.MethodOptions || h.isPrivileged(c) {
+		c.Next()
+		return
+	}
+	keys := h.rateLimitKeys(c)
+	ctx := c.Request.Context()
+
+	var report *ratelimit.Result
+	for _, key := range keys {
+		result, limited, err := h.limiter.Allow(ctx, key, class)
+		if err != nil {
+			h.log(c).Warn("Failed to check rate limit:", err)
+		}
+		if limited && (report == nil || !result.Allowed || result.Remaining < report.Remaining) {
+			report = &result
+		}
+		if limited && !result.Allowed {
+			break
+		}
+	}
+	if report != nil {
+		c.Header("RateLimit-Limit", strconv.Itoa(report.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(report.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(report.Reset))
+		if !report.Allowed {
+			metrics.RateLimited(class, metrics.LimitRate)
+			h.tooManyRequests(c, report.RetryAfter, fmt.Sprintf("Too many requests, retry in %s seconds", ceilSeconds(report.RetryAfter)))
+			return
+		}
+	}
+
+	// Bytes received and sent count against the daily quota
+	var received *countingBody
+	if quota := h.limiter.DailyQuota(); quota > 0 {
+		for _, key := range keys {
+			left, reset, err := h.limiter.QuotaLeft(ctx, key)
+			if err != nil {
+				h.log(c).Warn("Failed to check bandwidth quota:", err)
+			} else if left <= 0 {
+				metrics.RateLimited(class, metrics.LimitQuota)
+				h.tooManyRequests(c, reset, fmt.Sprintf("Daily bandwidth quota of %d bytes exceeded", quota))
+				return
+			}
+		}
+		received = &countingBody{ReadCloser: c.Request.Body}
+		c.Request.Body = received
+	}
+
+	if class == ratelimit.ClassUpload {
+		for _, key := range keys {
+			release, ok := h.limiter.AcquireUpload(key)
+			if !ok {
+				metrics.RateLimited(class, metrics.LimitConcurrency)
+				h.tooManyRequests(c, time.Second, fmt.Sprintf("Too many concurrent uploads: at most %d at a time", h.limiter.MaxConcurrentUploads()))
+				return
+			}
+			defer release()
+		}
+	}
+
+	c.Next()
+
+	if received != nil {
+		bytes := received.n.Load() + int64(max(c.Writer.Size(), 0))
+		for _, key := range keys {
+			if err := h.limiter.RecordUsage(context.WithoutCancel(ctx), key, bytes); err != nil {
+				h.log(c).Warn("Failed to record bandwidth usage:", err)
+			}
+		}
+	}
+}
+
+// tooManyRequests answers 429, telling the client when to retry
+func (h *FileHandler) tooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	c.Header("Retry-After", ceilSeconds(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":   true,
+		"message": message,
+	})
+}
+
+// ceilSeconds formats d in whole seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
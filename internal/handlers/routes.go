@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"api-file-upload-go/internal/buildinfo"
+	"api-file-upload-go/internal/metrics"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,27 +15,84 @@ func SetupRoutes(r *gin.Engine, fileHandler *FileHandler) {
 		// File routes
 		files := v1.Group("/files")
 		{
-			files.POST("/upload", fileHandler.UploadFile)
+			files.POST("/upload", fileHandler.UploadLimits, fileHandler.TrackUploadProgress, fileHandler.UploadFile)
+			files.POST("/batch", fileHandler.BatchFiles)
+			files.GET("/archive", fileHandler.DownloadLimits, metrics.TrackDownload, fileHandler.DownloadArchive)
+			files.POST("/archive", fileHandler.DownloadLimits, metrics.TrackDownload, fileHandler.DownloadArchive)
 			files.GET("", fileHandler.ListFiles)
 			files.GET("/:id", fileHandler.GetFile)
-			files.GET("/:id/download", fileHandler.DownloadFile)
+			files.GET("/:id/download", fileHandler.DownloadLimits, metrics.TrackDownload, fileHandler.DownloadFile)
+			files.GET("/:id/thumbnail", fileHandler.GetThumbnail)
+			files.PATCH("/:id", fileHandler.UpdateFile)
 			files.DELETE("/:id", fileHandler.DeleteFile)
+			files.PUT("/:id/content", fileHandler.ContentLimits, fileHandler.TrackUploadProgress, fileHandler.ReplaceFileContent)
+			files.GET("/:id/versions", fileHandler.ListFileVersions)
+			files.POST("/:id/versions/:version/promote", fileHandler.PromoteFileVersion)
+		}
+
+		// Folder routes
+		folders := v1.Group("/folders")
+		{
+			folders.POST("", fileHandler.CreateFolder)
+			folders.GET("", fileHandler.ListFolders)
+			folders.GET("/:id", fileHandler.GetFolder)
+			folders.GET("/:id/children", fileHandler.ListFolderChildren)
+			folders.PATCH("/:id", fileHandler.RenameFolder)
+			folders.POST("/:id/move", fileHandler.MoveFolder)
+			folders.DELETE("/:id", fileHandler.DeleteFolder)
+			folders.POST("/:id/restore", fileHandler.RestoreFolder)
+		}
+
+		// Webhook routes
+		hooks := v1.Group("/webhooks")
+		{
+			hooks.POST("", fileHandler.CreateWebhook)
+			hooks.GET("", fileHandler.ListWebhooks)
+			hooks.GET("/:id", fileHandler.GetWebhook)
+			hooks.PATCH("/:id", fileHandler.UpdateWebhook)
+			hooks.DELETE("/:id", fileHandler.DeleteWebhook)
+			hooks.GET("/:id/deliveries", fileHandler.ListWebhookDeliveries)
+			hooks.POST("/:id/replay", fileHandler.ReplayWebhook)
 		}
 
+		// Upload progress routes
+		v1.GET("/uploads", fileHandler.ListUploads)
+		v1.GET("/uploads/:id/progress", fileHandler.GetUploadProgress)
+
+		// Event stream route
+		v1.GET("/events", fileHandler.StreamLimits, fileHandler.StreamEvents)
+
+		// Search route
+		v1.GET("/search", fileHandler.SearchFiles)
+
+		// Trash route
+		v1.GET("/trash", fileHandler.ListTrash)
+
+		// Audit trail route
+		v1.GET("/audit", fileHandler.ListAuditLogs)
+
 		// Stats route
 		v1.GET("/stats", fileHandler.GetStats)
 	}
 
-	// Health check route
+	// Liveness, readiness and detailed health routes
+	r.GET("/livez", fileHandler.Livez)
+	r.GET("/readyz", fileHandler.Readyz)
 	r.GET("/health", fileHandler.HealthCheck)
 
+	// Prometheus metrics route
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// Root route
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "File Upload API",
-			"version": "1.0.0",
+			"version": buildinfo.Version,
 			"docs":    "/api/v1",
 			"health":  "/health",
+			"livez":   "/livez",
+			"readyz":  "/readyz",
+			"metrics": "/metrics",
 		})
 	})
 }
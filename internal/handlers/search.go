@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/fulltext"
+	"api-file-upload-go/internal/models"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxSearchQueryLength bounds the q parameter of SearchFiles
+const maxSearchQueryLength = 256
+
+// searchHeadlineOptions configures the ts_headline content snippets. Matches
+// are wrapped in <mark> tags; the rest of the snippet is escaped in Go.
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// searchHit is a file matched by SearchFiles with its rank and snippets
+type searchHit struct {
+	models.File
+	Rank             float64
+	NameHighlight    string
+	ContentHighlight string
+}
+
+// SearchFiles handles GET /api/v1/search. It matches q against file names,
+// tags, custom metadata and the text extracted from documents, best matches
+// first. The ListFiles filters (folder, type, media) apply as well.
+func (h *FileHandler) SearchFiles(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Search query (q) is required",
+		})
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Search query is too long",
+		})
+		return
+	}
+
+	limit, offset := parsePagination(c)
+
+	query, err := filterFiles(h.db.Model(&models.File{}), fileFilterFromQuery(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	hits, total, err := searchPostgres(query, q, limit, offset)
+	if err != nil {
+		h.log(c).Error("Failed to search files:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to search files",
+		})
+		return
+	}
+
+	results := make([]gin.H, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, gin.H{
+			"file": formatFile(hit.File),
+			"rank": hit.Rank,
+			"highlights": gin.H{
+				"name":    hit.NameHighlight,
+				"content": hit.ContentHighlight,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"results": results,
+			"query":   q,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	})
+}
+
+// searchPostgres matches q against the search_vector column (see the
+// database package) using web search syntax: quoted phrases, "or" and
+// -excluded terms
+func searchPostgres(query *gorm.DB, q string, limit, offset int) ([]searchHit, int64, error) {
+	const tsquery = "websearch_to_tsquery('simple', ?)"
+	query = query.Where("search_vector @@ "+tsquery, q)
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var hits []searchHit
+	err := query.
+		Select("files.*, "+
+			"ts_rank_cd(search_vector, "+tsquery+") AS rank, "+
+			"ts_headline('simple', original_name, "+tsquery+", 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS name_highlight, "+
+			"ts_headline('simple', COALESCE(content_text, ''), "+tsquery+", ?) AS content_highlight",
+			q, q, q, searchHeadlineOptions).
+		Order("rank DESC, uploaded_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range hits {
+		hits[i].NameHighlight = escapeHighlight(hits[i].NameHighlight)
+		hits[i].ContentHighlight = escapeHighlight(hits[i].ContentHighlight)
+	}
+	return hits, total, nil
+}
+
+// escapeHighlight escapes a ts_headline result, keeping only its <mark> tags
+func escapeHighlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "&lt;mark&gt;", "<mark>")
+	return strings.ReplaceAll(s, "&lt;/mark&gt;", "</mark>")
+}
+
+// indexContent extracts the searchable text of file's content in the
+// background, like the slow media extractors. Files of other types have
+// their previous text cleared.
+func (h *FileHandler) indexContent(file *models.File) {
+	if !fulltext.Supported(file.Extension) {
+		h.saveContentText(file, "")
+		return
+	}
+
+	source := *file
+	go func() {
+		h.mediaSlots <- struct{}{}
+		defer func() { <-h.mediaSlots }()
+
+		text, err := fulltext.Extract(source.Path, source.Extension)
+		if err != nil {
+			h.logger.Warnf("Failed to extract text from %s (ID: %d): %v", source.OriginalName, source.ID, err)
+		}
+		h.saveContentText(&source, text)
+	}()
+}
+
+// saveContentText stores extracted text while file still has the content it
+// was extracted from (see saveMediaInfo)
+func (h *FileHandler) saveContentText(file *models.File, text string) {
+	err := h.db.Model(&models.File{}).
+		Where("id = ? AND hash = ?", file.ID, file.Hash).
+		UpdateColumn("content_text", text).Error
+	if err != nil {
+		h.logger.Warn("Failed to save extracted text:", err)
+	}
+}
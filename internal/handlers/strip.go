@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/imaging"
+	"api-file-upload-go/internal/tracing"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// applyStripPolicy removes EXIF/XMP/IPTC metadata from a staged image when
+// the upload asks for it or the server strips by default. override is the
+// per-file strip_metadata option; the strip_metadata query parameter applies
+// to the whole request. Keeping metadata against the server default requires
+// the admin token.
+func (h *FileHandler) applyStripPolicy(ctx context.Context, c *gin.Context, upload *stagedUpload, name string, override *bool) error {
+	cfg := h.requestConfig(c)
+	stripByDefault := cfg.StripImageMetadata
+	strip := stripByDefault
+	if raw := c.Query("strip_metadata"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return &itemError{status: http.StatusBadRequest, message: "Invalid strip_metadata value"}
+		}
+		strip = value
+	}
+	if override != nil {
+		strip = *override
+	}
+
+	if !strip {
+		if stripByDefault && !h.isPrivileged(c) {
+			return &itemError{status: http.StatusForbidden, message: "Only administrators can keep image metadata"}
+		}
+		return nil
+	}
+
+	return h.stripUploadMetadata(ctx, cfg, upload, name)
+}
+
+// stripUploadMetadata rewrites a staged JPEG, PNG or WebP without its
+// metadata, updating the upload's hash and size to match the stored bytes.
+// The format is sniffed from the content, so images with another extension
+// or none are stripped too. With KeepImageOriginals the untouched file is
+// moved to the originals directory instead of being removed.
+func (h *FileHandler) stripUploadMetadata(ctx context.Context, cfg *config.Config, upload *stagedUpload, name string) (err error) {
+	src, err := os.Open(upload.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	format := imaging.StripFormat(src)
+	if format == "" {
+		return nil
+	}
+
+	_, span := tracing.Start(ctx, "upload.strip_metadata", attribute.String("file.name", name))
+	defer func() { tracing.End(span, err) }()
+
+	strippedPath := upload.path + ".stripped"
+	out, err := os.OpenFile(strippedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	counter := &countingWriter{}
+	stripped, err := imaging.StripMetadata(io.MultiWriter(out, hash, counter), src, upload.size, format)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || !stripped {
+		os.Remove(strippedPath)
+		if err == imaging.ErrMalformed {
+			return &itemError{status: http.StatusBadRequest, message: "Could not remove image metadata: file is not a valid image"}
+		}
+		return err
+	}
+
+	if cfg.KeepImageOriginals {
+		originalsDir := filepath.Join(cfg.UploadDir, "originals")
+		if err := os.MkdirAll(originalsDir, 0755); err != nil {
+			os.Remove(strippedPath)
+			return err
+		}
+		originalPath := filepath.Join(originalsDir, filepath.Base(upload.path))
+		if err := os.Rename(upload.path, originalPath); err != nil {
+			os.Remove(strippedPath)
+			return err
+		}
+		upload.originalPath = originalPath
+	}
+
+	if err := os.Rename(strippedPath, upload.path); err != nil {
+		os.Remove(strippedPath)
+		return err
+	}
+
+	h.logger.WithContext(ctx).Infof("Image metadata removed: %s (%d -> %d bytes)", name, upload.size, counter.n)
+	upload.hash = fmt.Sprintf("%x", hash.Sum(nil))
+	upload.size = counter.n
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
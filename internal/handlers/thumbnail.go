@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/imaging"
+	"api-file-upload-go/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultThumbnailSize is used when neither w nor h is given
+	defaultThumbnailSize = 256
+	// maxThumbnailSize bounds the requested width and height
+	maxThumbnailSize = 2048
+)
+
+// thumbnailMimeTypes are the formats thumbnails can be made from
+var thumbnailMimeTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// thumbnailParams are the normalized rendition options of a thumbnail request
+type thumbnailParams struct {
+	width  int
+	height int
+	fit    string
+	format string
+}
+
+// cacheName is the rendition's file name, unique per source content and options
+func (p thumbnailParams) cacheName(hash string) string {
+	ext := "jpg"
+	if p.format == "png" {
+		ext = "png"
+	}
+	return fmt.Sprintf("%s_%dx%d_%s.%s", hash, p.width, p.height, p.fit, ext)
+}
+
+// GetThumbnail handles serving a resized rendition of an image file. Renditions
+// are cached on disk by content hash and options, so each one is rendered once.
+func (h *FileHandler) GetThumbnail(c *gin.Context) {
+	file, ok := h.loadFile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if !thumbnailMimeTypes[file.MimeType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":   true,
+			"message": "Thumbnails are only available for JPEG, PNG, GIF and WebP images",
+		})
+		return
+	}
+
+	params, err := parseThumbnailParams(c, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	name := params.cacheName(file.Hash)
+	etag := `"` + name + `"`
+	if matchesETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cfg := h.requestConfig(c)
+	renditionPath := filepath.Join(cfg.ThumbnailDir, name)
+	if _, err := os.Stat(renditionPath); os.IsNotExist(err) {
+		if err := h.renderThumbnail(cfg, file, params, renditionPath); err != nil {
+			switch {
+			case os.IsNotExist(err):
+				c.JSON(http.StatusNotFound, gin.H{
+					"error":   true,
+					"message": "File not found on disk",
+				})
+			case err == imaging.ErrTooLarge:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error":   true,
+					"message": fmt.Sprintf("Image exceeds maximum size for thumbnails: %d pixels", cfg.MaxImagePixels),
+				})
+			case err == imaging.ErrUnsupported:
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{
+					"error":   true,
+					"message": "File content is not a supported image",
+				})
+			default:
+				h.log(c).Error("Failed to create thumbnail:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error":   true,
+					"message": "Failed to create thumbnail",
+				})
+			}
+			return
+		}
+		h.log(c).Infof("Thumbnail created: %s (ID: %d, %s)", file.OriginalName, file.ID, name)
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(renditionPath)
+}
+
+// parseThumbnailParams validates the w, h, fit and format query parameters
+func parseThumbnailParams(c *gin.Context, file *models.File) (thumbnailParams, error) {
+	params := thumbnailParams{fit: imaging.FitContain}
+
+	for _, dim := range []struct {
+		name  string
+		value *int
+	}{{"w", &params.width}, {"h", &params.height}} {
+		raw := c.Query(dim.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxThumbnailSize {
+			return params, fmt.Errorf("Invalid %s: must be between 1 and %d", dim.name, maxThumbnailSize)
+		}
+		*dim.value = parsed
+	}
+	if params.width == 0 && params.height == 0 {
+		params.width, params.height = defaultThumbnailSize, defaultThumbnailSize
+	}
+
+	if fit := strings.ToLower(c.Query("fit")); fit != "" {
+		if fit != imaging.FitContain && fit != imaging.FitCover && fit != imaging.FitFill {
+			return params, fmt.Errorf("Invalid fit: %s (use contain, cover or fill)", c.Query("fit"))
+		}
+		params.fit = fit
+	}
+
+	switch format := strings.ToLower(c.Query("format")); format {
+	case "":
+		// Keep transparency for formats that may have it
+		params.format = "jpeg"
+		if file.MimeType == "image/png" || file.MimeType == "image/gif" {
+			params.format = "png"
+		}
+	case "jpeg", "jpg":
+		params.format = "jpeg"
+	case "png":
+		params.format = "png"
+	default:
+		return params, fmt.Errorf("Unsupported thumbnail format: %s (use jpeg or png)", c.Query("format"))
+	}
+
+	return params, nil
+}
+
+// renderThumbnail creates the rendition of file at renditionPath. It is
+// written to a temporary file first so concurrent requests never serve a
+// partial image.
+func (h *FileHandler) renderThumbnail(cfg *config.Config, file *models.File, params thumbnailParams, renditionPath string) error {
+	img, err := imaging.Load(file.Path, cfg.MaxImagePixels)
+	if err != nil {
+		return err
+	}
+	thumb := imaging.Thumbnail(img, params.width, params.height, params.fit)
+
+	if err := os.MkdirAll(cfg.ThumbnailDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(cfg.ThumbnailDir, ".render-*")
+	if err != nil {
+		return err
+	}
+	if err := imaging.Encode(tmp, thumb, params.format); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), renditionPath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// removeRenditions deletes every cached thumbnail of the content with hash
+func (h *FileHandler) removeRenditions(hash string) {
+	if hash == "" {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(h.config.Load().ThumbnailDir, hash+"_*"))
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+			h.logger.Warn("Failed to delete thumbnail:", err)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UpdateFileRequest is the body accepted by UpdateFile. Every field is
+// optional; folder_id set to null moves the file to the root and metadata
+// keys set to null are removed.
+type UpdateFileRequest struct {
+	Name       *string            `json:"name"`
+	FolderID   json.RawMessage    `json:"folder_id"`
+	MimeType   *string            `json:"mime_type"`
+	Metadata   map[string]*string `json:"metadata"`
+	Visibility *string            `json:"visibility"`
+}
+
+// errRevisionMismatch is returned inside the update transaction when the file
+// changed since the client read it
+var errRevisionMismatch = errors.New("revision mismatch")
+
+// UpdateFile handles changing a file's metadata: name, folder, MIME type,
+// custom metadata and visibility. Clients can send the ETag from GetFile in
+// If-Match to guard against concurrent updates.
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	file, ok := h.loadFile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" && !matchesETag(ifMatch, fileETag(file)) {
+		c.Header("ETag", fileETag(file))
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":   true,
+			"message": "File has been modified, reload it and try again",
+		})
+		return
+	}
+
+	var req UpdateFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	changes := map[string]interface{}{}
+	setField := func(column string, from, to interface{}) {
+		updates[column] = to
+		changes[column] = gin.H{"from": from, "to": to}
+	}
+
+	// Rename
+	name := file.OriginalName
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if err := validateFileName(name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": err.Error(),
+			})
+			return
+		}
+		if err := h.validateUpload(h.requestConfig(c), name, 0); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": err.Error(),
+			})
+			return
+		}
+		if name != file.OriginalName {
+			setField("original_name", file.OriginalName, name)
+			if ext := strings.ToLower(filepath.Ext(name)); ext != file.Extension {
+				setField("extension", file.Extension, ext)
+			}
+			if req.MimeType == nil {
+				if mimeType := utils.GetMimeType(name); mimeType != file.MimeType {
+					setField("mime_type", file.MimeType, mimeType)
+				}
+			}
+		}
+	}
+
+	// Move
+	folderID := file.FolderID
+	folderPath := parentVirtualPath(file.VirtualPath)
+	if len(req.FolderID) > 0 {
+		if string(req.FolderID) == "null" {
+			folderID = nil
+			folderPath = ""
+		} else {
+			var id uint
+			if err := json.Unmarshal(req.FolderID, &id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   true,
+					"message": "Invalid folder ID",
+				})
+				return
+			}
+			folder, ok := h.loadFolder(c, strconv.FormatUint(uint64(id), 10))
+			if !ok {
+				return
+			}
+			folderID = &folder.ID
+			folderPath = folder.Path
+		}
+		if !sameFolder(folderID, file.FolderID) {
+			setField("folder_id", file.FolderID, folderID)
+		}
+	}
+
+	if changes["original_name"] != nil || changes["folder_id"] != nil {
+		if virtualPath := joinVirtualPath(folderPath, name); virtualPath != file.VirtualPath {
+			setField("virtual_path", file.VirtualPath, virtualPath)
+		}
+	}
+
+	// MIME type override
+	if req.MimeType != nil {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(*req.MimeType))
+		if err != nil || !strings.Contains(mediaType, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": "Invalid MIME type",
+			})
+			return
+		}
+		if mediaType != file.MimeType {
+			setField("mime_type", file.MimeType, mediaType)
+		}
+	}
+
+	// Custom metadata (merged; null removes a key)
+	var newMetadata map[string]string
+	if req.Metadata != nil {
+		metadata := map[string]string{}
+		for key, value := range file.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range req.Metadata {
+			if strings.TrimSpace(key) == "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   true,
+					"message": "Metadata keys cannot be empty",
+				})
+				return
+			}
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = *value
+			}
+		}
+		if fmt.Sprint(metadata) != fmt.Sprint(file.Metadata) {
+			// Serialized fields can't go through a map update, so metadata
+			// is written separately inside the transaction
+			changes["metadata"] = gin.H{"from": file.Metadata, "to": metadata}
+			newMetadata = metadata
+		}
+	}
+
+	// Visibility
+	if req.Visibility != nil {
+		visibility := strings.ToLower(strings.TrimSpace(*req.Visibility))
+		if visibility != models.VisibilityPrivate && visibility != models.VisibilityPublic {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   true,
+				"message": fmt.Sprintf("Invalid visibility: %s", *req.Visibility),
+			})
+			return
+		}
+		if visibility != file.Visibility {
+			setField("visibility", file.Visibility, visibility)
+		}
+	}
+
+	if len(changes) == 0 {
+		c.Header("ETag", fileETag(file))
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "No changes",
+			"data":    formatFile(*file),
+		})
+		return
+	}
+
+	revision := file.Revision
+	updates["revision"] = revision + 1
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Names must be unique within a folder
+		if changes["original_name"] != nil || changes["folder_id"] != nil {
+			taken, err := h.nameTakenInFolder(tx, folderID, name, 0, file.ID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return errNameTaken
+			}
+		}
+
+		result := tx.Model(file).Where("revision = ?", revision).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRevisionMismatch
+		}
+
+		if newMetadata != nil {
+			if err := tx.Model(file).Select("metadata").Updates(&models.File{Metadata: newMetadata}).Error; err != nil {
+				return err
+			}
+			file.Metadata = newMetadata
+		}
+
+		return recordAudit(tx, c, "file.update", "file", file.ID, map[string]interface{}{
+			"changes": changes,
+		})
+	})
+	if err != nil {
+		switch {
+		case err == errNameTaken || isNameConflict(err):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   true,
+				"message": fmt.Sprintf("An item named %s already exists in this folder", name),
+			})
+		case err == errRevisionMismatch:
+			c.JSON(http.StatusPreconditionFailed, gin.H{
+				"error":   true,
+				"message": "File has been modified, reload it and try again",
+			})
+		default:
+			h.log(c).Error("Failed to update file:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   true,
+				"message": "Failed to update file",
+			})
+		}
+		return
+	}
+
+	h.log(c).Infof("File updated successfully: %s (ID: %d, revision: %d)", file.OriginalName, file.ID, file.Revision)
+
+	c.Header("ETag", fileETag(file))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File updated successfully",
+		"data":    formatFile(*file),
+	})
+}
+
+// fileETag returns the entity tag of a file's current revision
+func fileETag(file *models.File) string {
+	return fmt.Sprintf(`"%d-%d"`, file.ID, file.Revision)
+}
+
+// matchesETag reports whether an If-Match header value matches etag
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sameFolder compares two optional folder IDs
+func sameFolder(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// validateFileName checks that name can be used as a file name
+func validateFileName(name string) error {
+	if name == "" {
+		return errors.New("File name is required")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("Invalid file name: %s", name)
+	}
+	if len(name) > 255 {
+		return errors.New("File name is too long")
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/progress"
+	"api-file-upload-go/internal/tracing"
+	"api-file-upload-go/internal/utils"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// maxFormFieldSize bounds the non-file parts (folder_id, metadata) of an upload
+const maxFormFieldSize = 1 << 20
+
+// errFileTooLarge is returned by writeUpload when the content exceeds MaxFileSize
+var errFileTooLarge = errors.New("file too large")
+
+// stagedUpload is a file part that has been streamed to disk but not yet
+// recorded in the database
+type stagedUpload struct {
+	index    int
+	fileName string
+	path     string
+	diskName string
+	hash     string
+	size     int64
+	err      error
+
+	// Set for entries extracted from an uploaded archive
+	archive string
+	entry   string
+	dir     string
+
+	// Unsanitized content retained after stripping image metadata
+	originalPath string
+}
+
+// remove deletes the files written for an upload that won't be kept
+func (u *stagedUpload) remove() {
+	if u.path != "" {
+		os.Remove(u.path)
+	}
+	if u.originalPath != "" {
+		os.Remove(u.originalPath)
+	}
+}
+
+// uploadOptions are the optional per-file settings sent in the "metadata" part
+type uploadOptions struct {
+	Name          string            `json:"name"`
+	FolderID      *uint             `json:"folder_id"`
+	Metadata      map[string]string `json:"metadata"`
+	Tags          []string          `json:"tags"`
+	Visibility    string            `json:"visibility"`
+	StripMetadata *bool             `json:"strip_metadata"`
+}
+
+// uploadOptionsSet maps a file name or part index to its options
+type uploadOptionsSet map[string]uploadOptions
+
+// lookup returns the options for upload, matching by file name first and by
+// part index second
+func (o uploadOptionsSet) lookup(upload *stagedUpload) uploadOptions {
+	if opts, ok := o[upload.fileName]; ok {
+		return opts
+	}
+	return o[strconv.Itoa(upload.index)]
+}
+
+// readUploadParts streams every "file" part of a multipart request to disk,
+// validating each one independently, and collects the plain form fields.
+// Per-file problems are recorded on the staged upload; request-level problems
+// (body too large, too many files) abort the whole request. With extract set,
+// archives are accepted regardless of the file rules, which are applied to
+// their entries instead. The body is capped at MAX_REQUEST_SIZE by
+// UploadLimits, or by ContentLimits for new versions of a file. ctx carries
+// the span the parts are written under.
+func (h *FileHandler) readUploadParts(ctx context.Context, c *gin.Context, extract bool) ([]*stagedUpload, map[string]string, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, &itemError{status: http.StatusBadRequest, message: "No file uploaded"}
+	}
+
+	cfg := h.requestConfig(c)
+	var uploads []*stagedUpload
+	fields := map[string]string{}
+	timestamp := time.Now().UnixNano()
+
+	fail := func(err error) ([]*stagedUpload, map[string]string, error) {
+		removeStagedUploads(uploads)
+		if errors.Is(err, progress.ErrCancelled) {
+			return nil, nil, h.cancelledUploadError(cfg, err)
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			metrics.UploadRejected(metrics.RejectRequestTooLarge)
+			return nil, nil, &itemError{
+				status:  http.StatusRequestEntityTooLarge,
+				message: fmt.Sprintf("Request exceeds maximum upload size: %d bytes", maxBytesErr.Limit),
+			}
+		}
+		return nil, nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) || errors.Is(err, progress.ErrCancelled) {
+				return fail(err)
+			}
+			metrics.UploadRejected(metrics.RejectInvalidBody)
+			return fail(&itemError{status: http.StatusBadRequest, message: "Invalid multipart body"})
+		}
+
+		// Plain form fields
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			part.Close()
+			if err != nil {
+				return fail(err)
+			}
+			if len(value) > maxFormFieldSize {
+				return fail(&itemError{status: http.StatusBadRequest, message: fmt.Sprintf("Form field too large: %s", part.FormName())})
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		if len(uploads) >= cfg.MaxUploadFiles {
+			part.Close()
+			metrics.UploadRejected(metrics.RejectTooManyFiles)
+			return fail(&itemError{
+				status:  http.StatusRequestEntityTooLarge,
+				message: fmt.Sprintf("Too many files: maximum is %d per request", cfg.MaxUploadFiles),
+			})
+		}
+
+		upload := &stagedUpload{
+			index:    len(uploads),
+			fileName: filepath.Base(part.FileName()),
+		}
+		uploads = append(uploads, upload)
+
+		// Reject by extension before writing anything
+		isArchive := extract && archiveFormat(upload.fileName) != ""
+		if !isArchive {
+			if err := h.validateUpload(cfg, upload.fileName, 0); err != nil {
+				upload.err = &itemError{status: http.StatusBadRequest, message: err.Error()}
+				part.Close()
+				continue
+			}
+		}
+
+		// Generate unique filename
+		upload.diskName = fmt.Sprintf("%d_%s", timestamp, upload.fileName)
+		if upload.index > 0 {
+			upload.diskName = fmt.Sprintf("%d_%d_%s", timestamp, upload.index, upload.fileName)
+		}
+
+		sizeLimit := cfg.MaxFileSize
+		if isArchive {
+			sizeLimit = cfg.MaxRequestSize
+		}
+		upload.path, upload.hash, upload.size, err = h.writeUpload(ctx, cfg, part, upload.diskName, sizeLimit)
+		part.Close()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesErr), errors.Is(err, progress.ErrCancelled):
+				return fail(err)
+			case err == errFileTooLarge:
+				upload.err = &itemError{
+					status:  http.StatusBadRequest,
+					message: fmt.Sprintf("File size exceeds maximum allowed size: %d bytes", sizeLimit),
+				}
+			default:
+				upload.err = &itemError{status: http.StatusInternalServerError, message: err.Error()}
+			}
+		}
+	}
+
+	return uploads, fields, nil
+}
+
+// persistUpload turns a staged upload into a file record (with its initial
+// version), applying the per-file options and the folder/dedup rules
+func (h *FileHandler) persistUpload(c *gin.Context, upload *stagedUpload, opts uploadOptions, defaultFolder string) (_ *models.File, err error) {
+	ctx, span := tracing.Start(c.Request.Context(), "upload.persist", attribute.String("file.name", upload.fileName))
+	defer func() { tracing.End(span, err) }()
+	db := h.db.WithContext(ctx)
+
+	if upload.err != nil {
+		return nil, upload.err
+	}
+
+	name := upload.fileName
+	if opts.Name != "" {
+		name = strings.TrimSpace(opts.Name)
+		if err := validateFileName(name); err != nil {
+			metrics.UploadRejected(metrics.RejectInvalidOptions)
+			return nil, &itemError{status: http.StatusBadRequest, message: err.Error()}
+		}
+		if err := h.validateUpload(h.requestConfig(c), name, 0); err != nil {
+			return nil, &itemError{status: http.StatusBadRequest, message: err.Error()}
+		}
+	}
+
+	visibility := models.VisibilityPrivate
+	if opts.Visibility != "" {
+		visibility = strings.ToLower(strings.TrimSpace(opts.Visibility))
+		if visibility != models.VisibilityPrivate && visibility != models.VisibilityPublic {
+			metrics.UploadRejected(metrics.RejectInvalidOptions)
+			return nil, &itemError{status: http.StatusBadRequest, message: fmt.Sprintf("Invalid visibility: %s", opts.Visibility)}
+		}
+	}
+
+	// Remove image metadata before hashing, so dedup sees the stored bytes
+	if err := h.applyStripPolicy(ctx, c, upload, name, opts.StripMetadata); err != nil {
+		return nil, err
+	}
+
+	// Resolve target folder (optional)
+	folderID := opts.FolderID
+	if folderID == nil && defaultFolder != "" {
+		id, err := strconv.ParseUint(defaultFolder, 10, 32)
+		if err != nil {
+			return nil, &itemError{status: http.StatusBadRequest, message: "Invalid folder ID"}
+		}
+		folderIDValue := uint(id)
+		folderID = &folderIDValue
+	}
+
+	// Archive entries recreate their directories below the target folder
+	if upload.dir != "" {
+		folder, err := h.ensureFolderPath(folderID, upload.dir)
+		if err != nil {
+			return nil, err
+		}
+		folderID = &folder.ID
+	}
+
+	virtualPath := "/" + name
+	if folderID != nil {
+		folder, err := findFolder(db, *folderID)
+		if err != nil {
+			return nil, err
+		}
+		folderID = &folder.ID
+		virtualPath = joinVirtualPath(folder.Path, name)
+	}
+
+	// Names must be unique within a folder
+	nameTaken := &itemError{status: http.StatusConflict, message: fmt.Sprintf("An item named %s already exists in this folder", name)}
+	taken, err := h.nameTakenInFolder(db, folderID, name, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		metrics.UploadRejected(metrics.RejectNameConflict)
+		return nil, nameTaken
+	}
+
+	// Check if file already exists
+	if err := duplicateContent(db, upload.hash, 0); err != nil {
+		return nil, err
+	}
+
+	// Create file record
+	fileRecord := models.File{
+		Name:           upload.diskName,
+		OriginalName:   name,
+		Path:           upload.path,
+		Size:           upload.size,
+		MimeType:       utils.GetMimeType(name),
+		Extension:      strings.ToLower(filepath.Ext(name)),
+		Hash:           upload.hash,
+		FolderID:       folderID,
+		VirtualPath:    virtualPath,
+		CurrentVersion: 1,
+		Metadata:       opts.Metadata,
+		Tags:           applyTags(nil, opts.Tags, nil),
+		Visibility:     visibility,
+		Revision:       1,
+		OriginalPath:   upload.originalPath,
+	}
+
+	// Save to database together with the initial version
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&fileRecord).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.FileVersion{
+			FileID:       fileRecord.ID,
+			Version:      fileRecord.CurrentVersion,
+			Name:         fileRecord.Name,
+			Path:         fileRecord.Path,
+			Size:         fileRecord.Size,
+			MimeType:     fileRecord.MimeType,
+			Hash:         fileRecord.Hash,
+			UploadedBy:   requestActor(c),
+			OriginalPath: fileRecord.OriginalPath,
+		}).Error; err != nil {
+			return err
+		}
+		return h.enqueueFileEvents(tx, c, models.EventFileUploaded, fileRecord)
+	}); err != nil {
+		if isNameConflict(err) {
+			metrics.UploadRejected(metrics.RejectNameConflict)
+			return nil, nameTaken
+		}
+		if isContentConflict(err) {
+			return nil, duplicateContent(db, upload.hash, 0)
+		}
+		return nil, err
+	}
+	h.notifyEvents()
+
+	h.extractMedia(&fileRecord)
+	h.indexContent(&fileRecord)
+
+	return &fileRecord, nil
+}
+
+// duplicateContent returns a 409 itemError when a live file other than
+// excludeID already has content hash. Files in the trash don't count; one
+// can only be restored while no live file has its content.
+func duplicateContent(db *gorm.DB, hash string, excludeID uint) error {
+	var existingFile models.File
+	err := db.Where("hash = ? AND id <> ?", hash, excludeID).First(&existingFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	metrics.DedupHit()
+	return &itemError{status: http.StatusConflict, message: "File already exists", fileID: existingFile.ID}
+}
+
+// writeUpload streams r into fileName inside the upload directory, hashing it
+// on the way, and returns the path, hash and size. Content larger than limit
+// (0 = unlimited) fails with errFileTooLarge; *http.MaxBytesError and
+// progress.ErrCancelled are passed through as well. Other errors are safe to
+// show to clients.
+func (h *FileHandler) writeUpload(ctx context.Context, cfg *config.Config, r io.Reader, fileName string, limit int64) (_ string, _ string, size int64, err error) {
+	_, span := tracing.Start(ctx, "storage.write", attribute.String("file.name", fileName))
+	defer func() {
+		span.SetAttributes(attribute.Int64("file.size", size))
+		tracing.End(span, err)
+	}()
+
+	// Create upload directory if it doesn't exist
+	uploadDir := cfg.UploadDir
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		metrics.StorageError(metrics.OpWrite)
+		h.logger.WithContext(ctx).Error("Failed to create upload directory:", err)
+		return "", "", 0, errors.New("Failed to create upload directory")
+	}
+
+	destPath := filepath.Join(uploadDir, fileName)
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		metrics.StorageError(metrics.OpWrite)
+		h.logger.WithContext(ctx).Error("Failed to save uploaded file:", err)
+		return "", "", 0, errors.New("Failed to save uploaded file")
+	}
+
+	// Read one byte past the limit so oversized files can be detected
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	hash := md5.New()
+	size, err = io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, progress.ErrCancelled) {
+			return "", "", 0, err
+		}
+		metrics.StorageError(metrics.OpWrite)
+		h.logger.WithContext(ctx).Error("Failed to save uploaded file:", err)
+		return "", "", 0, errors.New("Failed to save uploaded file")
+	}
+
+	if limit > 0 && size > limit {
+		os.Remove(destPath)
+		metrics.UploadRejected(metrics.RejectFileTooLarge)
+		return "", "", 0, errFileTooLarge
+	}
+
+	return destPath, fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+}
+
+// removeStagedUploads deletes the files written for uploads that won't be kept
+func removeStagedUploads(uploads []*stagedUpload) {
+	for _, upload := range uploads {
+		upload.remove()
+	}
+}
+
+// respondItemError writes err as an API error response, using its status and
+// message when it is an itemError and fallback otherwise
+func (h *FileHandler) respondItemError(c *gin.Context, err error, fallback string) {
+	var itemErr *itemError
+	if !errors.As(err, &itemErr) {
+		h.log(c).Error(fallback+":", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": fallback,
+		})
+		return
+	}
+
+	response := gin.H{
+		"error":   true,
+		"message": itemErr.message,
+	}
+	if itemErr.fileID != 0 {
+		response["file_id"] = itemErr.fileID
+	}
+	c.JSON(itemErr.status, response)
+}
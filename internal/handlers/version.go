@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/metrics"
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/tracing"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReplaceFileContent handles uploading a new version of an existing file. The
+// file keeps its ID and name; the previous content stays available in its history.
+// As the name is kept, the new content must have the same extension.
+func (h *FileHandler) ReplaceFileContent(c *gin.Context) {
+	fileRecord, ok := h.loadFile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := h.db.WithContext(ctx)
+
+	// Stream the uploaded file to disk, as uploads do
+	receiveCtx, span := tracing.Start(ctx, "upload.receive")
+	uploads, _, err := h.readUploadParts(receiveCtx, c, false)
+	tracing.End(span, err)
+	if err != nil {
+		h.respondItemError(c, err, "Failed to read upload")
+		return
+	}
+	if len(uploads) != 1 {
+		removeStagedUploads(uploads)
+		message := "No file uploaded"
+		if len(uploads) > 1 {
+			message = "Only one file can be uploaded as a new version"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": message,
+		})
+		return
+	}
+	upload := uploads[0]
+	if upload.err != nil {
+		h.respondItemError(c, upload.err, "Failed to save uploaded file")
+		return
+	}
+	if ext := strings.ToLower(filepath.Ext(upload.fileName)); ext != fileRecord.Extension {
+		upload.remove()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("A new version must have the same extension as the file (%s), rename the file first", fileRecord.Extension),
+		})
+		return
+	}
+
+	if err := h.applyStripPolicy(ctx, c, upload, upload.fileName, nil); err != nil {
+		upload.remove()
+		h.respondItemError(c, err, "Failed to process uploaded file")
+		return
+	}
+
+	if upload.hash == fileRecord.Hash {
+		upload.remove()
+		metrics.DedupHit()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   true,
+			"message": "Content is identical to the current version",
+			"version": fileRecord.CurrentVersion,
+		})
+		return
+	}
+
+	// Content must stay unique across files
+	if err := duplicateContent(db, upload.hash, fileRecord.ID); err != nil {
+		upload.remove()
+		h.respondItemError(c, err, "Failed to check for duplicate content")
+		return
+	}
+
+	version := models.FileVersion{
+		FileID:       fileRecord.ID,
+		Name:         upload.diskName,
+		Path:         upload.path,
+		Size:         upload.size,
+		MimeType:     fileRecord.MimeType,
+		Hash:         upload.hash,
+		UploadedBy:   requestActor(c),
+		OriginalPath: upload.originalPath,
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the file so concurrent replaces get consecutive versions
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(fileRecord, fileRecord.ID).Error; err != nil {
+			return err
+		}
+
+		// Make sure the content being replaced is part of the history
+		if err := h.ensureInitialVersion(tx, fileRecord); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.FileVersion{}).
+			Where("file_id = ?", fileRecord.ID).
+			Select("COALESCE(MAX(version), 0) + 1").
+			Scan(&version.Version).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(fileRecord).Updates(map[string]interface{}{
+			"name":            version.Name,
+			"path":            version.Path,
+			"size":            version.Size,
+			"hash":            version.Hash,
+			"current_version": version.Version,
+			"revision":        fileRecord.Revision + 1,
+			"original_path":   version.OriginalPath,
+		}).Error; err != nil {
+			return err
+		}
+		return h.enqueueFileEvents(tx, c, models.EventFileVersioned, *fileRecord)
+	}); err != nil {
+		// Clean up uploaded file if database save fails
+		upload.remove()
+		if isContentConflict(err) {
+			h.respondItemError(c, duplicateContent(db, upload.hash, fileRecord.ID), "Failed to check for duplicate content")
+			return
+		}
+		h.log(c).Error("Failed to save file version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to save file version",
+		})
+		return
+	}
+	h.notifyEvents()
+
+	h.pruneVersions(h.requestConfig(c), fileRecord)
+	h.extractMedia(fileRecord)
+	h.indexContent(fileRecord)
+
+	h.log(c).Infof("File version uploaded successfully: %s (ID: %d, version: %d)", fileRecord.OriginalName, fileRecord.ID, version.Version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File version uploaded successfully",
+		"data":    formatFile(*fileRecord),
+	})
+}
+
+// ListFileVersions handles listing a file's version history, newest first
+func (h *FileHandler) ListFileVersions(c *gin.Context) {
+	file, ok := h.loadFile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := h.ensureInitialVersion(h.db, file); err != nil {
+		h.log(c).Error("Failed to record initial file version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to record file version",
+		})
+		return
+	}
+
+	var versions []models.FileVersion
+	if err := h.db.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		h.log(c).Error("Failed to list file versions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list file versions",
+		})
+		return
+	}
+
+	versionList := []gin.H{}
+	for _, version := range versions {
+		versionList = append(versionList, gin.H{
+			"version":      version.Version,
+			"size":         version.Size,
+			"mime_type":    version.MimeType,
+			"hash":         version.Hash,
+			"uploaded_by":  version.UploadedBy,
+			"created_at":   version.CreatedAt,
+			"current":      version.Version == file.CurrentVersion,
+			"download_url": fmt.Sprintf("/api/v1/files/%d/download?version=%d", file.ID, version.Version),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"file_id":         file.ID,
+			"current_version": file.CurrentVersion,
+			"versions":        versionList,
+		},
+	})
+}
+
+// PromoteFileVersion handles making an older version the current content
+// again. Clients can send the file's ETag in If-Match, as for UpdateFile.
+func (h *FileHandler) PromoteFileVersion(c *gin.Context) {
+	file, ok := h.loadFile(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && !matchesETag(ifMatch, fileETag(file)) {
+		c.Header("ETag", fileETag(file))
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":   true,
+			"message": "File has been modified, reload it and try again",
+		})
+		return
+	}
+
+	version, ok := h.loadFileVersion(c, file, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	if version.Version != file.CurrentVersion {
+		if _, err := os.Stat(version.Path); os.IsNotExist(err) {
+			metrics.StorageError(metrics.OpRead)
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "File version not found on disk",
+			})
+			return
+		}
+	}
+
+	promoted := false
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the file so a concurrent new version or promote waits, then
+		// check it against If-Match again
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(file, file.ID).Error; err != nil {
+			return err
+		}
+		if ifMatch != "" && !matchesETag(ifMatch, fileETag(file)) {
+			return errRevisionMismatch
+		}
+		if version.Version == file.CurrentVersion {
+			return nil
+		}
+
+		if err := duplicateContent(tx, version.Hash, file.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(file).Updates(map[string]interface{}{
+			"name":            version.Name,
+			"path":            version.Path,
+			"size":            version.Size,
+			"mime_type":       version.MimeType,
+			"extension":       strings.ToLower(filepath.Ext(version.Name)),
+			"hash":            version.Hash,
+			"current_version": version.Version,
+			"revision":        file.Revision + 1,
+			"original_path":   version.OriginalPath,
+		}).Error; err != nil {
+			return err
+		}
+		promoted = true
+		return h.enqueueFileEvents(tx, c, models.EventFileVersioned, *file)
+	}); err != nil {
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "File not found",
+			})
+		case err == errRevisionMismatch:
+			c.Header("ETag", fileETag(file))
+			c.JSON(http.StatusPreconditionFailed, gin.H{
+				"error":   true,
+				"message": "File has been modified, reload it and try again",
+			})
+		case isContentConflict(err):
+			h.respondItemError(c, duplicateContent(h.db, version.Hash, file.ID), "Failed to check for duplicate content")
+		default:
+			h.respondItemError(c, err, "Failed to promote file version")
+		}
+		return
+	}
+
+	if promoted {
+		h.notifyEvents()
+		h.extractMedia(file)
+		h.indexContent(file)
+		h.log(c).Infof("File version promoted: %s (ID: %d, version: %d)", file.OriginalName, file.ID, version.Version)
+	}
+
+	c.Header("ETag", fileETag(file))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "File version promoted successfully",
+		"data":    formatFile(*file),
+	})
+}
+
+// loadFileVersion parses a version number and loads it for file, writing the
+// error response itself when it fails
+func (h *FileHandler) loadFileVersion(c *gin.Context, file *models.File, versionStr string) (*models.FileVersion, bool) {
+	number, err := strconv.Atoi(versionStr)
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid file version",
+		})
+		return nil, false
+	}
+
+	var version models.FileVersion
+	if err := h.db.Where("file_id = ? AND version = ?", file.ID, number).First(&version).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "File version not found",
+			})
+			return nil, false
+		}
+		h.log(c).Error("Failed to get file version:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get file version",
+		})
+		return nil, false
+	}
+
+	return &version, true
+}
+
+// ensureInitialVersion backfills the history of files uploaded before
+// versioning existed, recording their current content as a version
+func (h *FileHandler) ensureInitialVersion(tx *gorm.DB, file *models.File) error {
+	var count int64
+	if err := tx.Model(&models.FileVersion{}).Where("file_id = ?", file.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return tx.Create(&models.FileVersion{
+		FileID:       file.ID,
+		Version:      file.CurrentVersion,
+		Name:         file.Name,
+		Path:         file.Path,
+		Size:         file.Size,
+		MimeType:     file.MimeType,
+		Hash:         file.Hash,
+		CreatedAt:    file.UploadedAt,
+		OriginalPath: file.OriginalPath,
+	}).Error
+}
+
+// pruneVersions enforces MaxFileVersions by removing the oldest versions of
+// file (never the current one) from the database and from disk
+func (h *FileHandler) pruneVersions(cfg *config.Config, file *models.File) {
+	maxVersions := cfg.MaxFileVersions
+	if maxVersions <= 0 {
+		return
+	}
+
+	var versions []models.FileVersion
+	if err := h.db.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		h.logger.Warn("Failed to load file versions for pruning:", err)
+		return
+	}
+
+	kept := 0
+	for _, version := range versions {
+		if version.Version == file.CurrentVersion || kept < maxVersions-1 {
+			if version.Version != file.CurrentVersion {
+				kept++
+			}
+			continue
+		}
+
+		if err := h.db.Delete(&version).Error; err != nil {
+			h.logger.Warn("Failed to delete file version:", err)
+			continue
+		}
+		if version.Path != file.Path {
+			if err := os.Remove(version.Path); err != nil && !os.IsNotExist(err) {
+				metrics.StorageError(metrics.OpDelete)
+				h.logger.Warn("Failed to delete file version from disk:", err)
+			}
+			h.removeRenditions(version.Hash)
+		}
+		if version.OriginalPath != "" && version.OriginalPath != file.OriginalPath {
+			if err := os.Remove(version.OriginalPath); err != nil && !os.IsNotExist(err) {
+				metrics.StorageError(metrics.OpDelete)
+				h.logger.Warn("Failed to delete file version original from disk:", err)
+			}
+		}
+		h.logger.Infof("File version pruned: %s (ID: %d, version: %d)", file.OriginalName, file.ID, version.Version)
+	}
+}
+
+// removeVersionFiles deletes the stored content (with retained originals and
+// thumbnails) of every non-current version of file
+func (h *FileHandler) removeVersionFiles(file *models.File) {
+	var versions []models.FileVersion
+	if err := h.db.Where("file_id = ?", file.ID).Find(&versions).Error; err != nil {
+		h.logger.Warn("Failed to load file versions:", err)
+		return
+	}
+
+	for _, version := range versions {
+		if version.Path == file.Path {
+			continue
+		}
+		if err := os.Remove(version.Path); err != nil && !os.IsNotExist(err) {
+			metrics.StorageError(metrics.OpDelete)
+			h.logger.Warn("Failed to delete file version from disk:", err)
+		}
+		h.removeRenditions(version.Hash)
+		if version.OriginalPath != "" && version.OriginalPath != file.OriginalPath {
+			if err := os.Remove(version.OriginalPath); err != nil && !os.IsNotExist(err) {
+				metrics.StorageError(metrics.OpDelete)
+				h.logger.Warn("Failed to delete file version original from disk:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"api-file-upload-go/internal/models"
+	"api-file-upload-go/internal/webhooks"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxReplayEvents bounds the events requeued by one replay request
+const maxReplayEvents = 1000
+
+// WebhookRequest is the body accepted by CreateWebhook and UpdateWebhook.
+// Every field is optional on update.
+type WebhookRequest struct {
+	URL         *string  `json:"url"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Secret      *string  `json:"secret"`
+	Active      *bool    `json:"active"`
+}
+
+// ReplayRequest is the body accepted by ReplayWebhook: either explicit
+// deliveries, or every event sent to the webhook in a time range (optionally
+// only the deliveries that failed)
+type ReplayRequest struct {
+	DeliveryIDs []uint     `json:"delivery_ids"`
+	Since       *time.Time `json:"since"`
+	Until       *time.Time `json:"until"`
+	Status      string     `json:"status"`
+}
+
+// enqueueFileEvents records a lifecycle event for each file in the webhook
+// outbox, inside the transaction making the change. Callers call
+// notifyEvents once the transaction has committed.
+func (h *FileHandler) enqueueFileEvents(tx *gorm.DB, c *gin.Context, eventType string, files ...models.File) error {
+	data := make([]map[string]interface{}, len(files))
+	for i, file := range files {
+		data[i] = map[string]interface{}{
+			"file":  formatFile(file),
+			"actor": requestActor(c),
+		}
+	}
+	return webhooks.Enqueue(tx, requestTenant(c), eventType, data...)
+}
+
+// notifyEvents tells the webhook dispatcher and the event stream about
+// newly committed events
+func (h *FileHandler) notifyEvents() {
+	h.webhooks.Notify()
+	h.events.Wake()
+}
+
+// CreateWebhook handles subscribing an endpoint to file events for the
+// request's tenant. The signing secret is generated unless one is given, and
+// is only returned in this response.
+func (h *FileHandler) CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+	if req.URL == nil || len(req.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "url and events are required",
+		})
+		return
+	}
+
+	webhook := models.Webhook{
+		TenantID: requestTenant(c),
+		Active:   true,
+	}
+	if err := applyWebhookRequest(&webhook, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+	if webhook.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			h.log(c).Error("Failed to generate webhook secret:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   true,
+				"message": "Failed to create webhook",
+			})
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		h.log(c).Error("Failed to create webhook:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to create webhook",
+		})
+		return
+	}
+
+	h.log(c).Infof("Webhook created: %s (ID: %d, tenant: %s)", webhook.URL, webhook.ID, webhook.TenantID)
+
+	data := formatWebhook(webhook)
+	data["secret"] = webhook.Secret
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Webhook created successfully",
+		"data":    data,
+	})
+}
+
+// ListWebhooks handles listing the tenant's webhooks
+func (h *FileHandler) ListWebhooks(c *gin.Context) {
+	var hooks []models.Webhook
+	if err := h.db.Where("tenant_id = ?", requestTenant(c)).Order("id").Find(&hooks).Error; err != nil {
+		h.log(c).Error("Failed to list webhooks:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list webhooks",
+		})
+		return
+	}
+
+	hookList := []gin.H{}
+	for _, hook := range hooks {
+		hookList = append(hookList, formatWebhook(hook))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"webhooks":    hookList,
+			"event_types": models.WebhookEventTypes,
+		},
+	})
+}
+
+// GetWebhook handles getting a webhook by ID
+func (h *FileHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    formatWebhook(*webhook),
+	})
+}
+
+// UpdateWebhook handles changing a webhook's URL, events, description,
+// secret or active flag
+func (h *FileHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+	if req.Events != nil && len(req.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "events cannot be empty",
+		})
+		return
+	}
+	if err := applyWebhookRequest(webhook, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.db.Model(webhook).Select("url", "events", "description", "secret", "active").Updates(webhook).Error; err != nil {
+		h.log(c).Error("Failed to update webhook:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to update webhook",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook updated successfully",
+		"data":    formatWebhook(*webhook),
+	})
+}
+
+// DeleteWebhook handles removing a webhook. Its pending deliveries are
+// dropped when the dispatcher reaches them.
+func (h *FileHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(webhook).Error; err != nil {
+		h.log(c).Error("Failed to delete webhook:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to delete webhook",
+		})
+		return
+	}
+
+	h.log(c).Infof("Webhook deleted: %s (ID: %d, tenant: %s)", webhook.URL, webhook.ID, webhook.TenantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// ListWebhookDeliveries handles the delivery log of a webhook, newest first,
+// optionally filtered by status (pending, succeeded, failed) and event type.
+// Response bodies are only shown with the admin token, so a webhook can't be
+// used to read what an endpoint answers.
+func (h *FileHandler) ListWebhookDeliveries(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	limit, offset := parsePagination(c)
+
+	query := h.db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if eventType := c.Query("event"); eventType != "" {
+		query = query.Where("event_id IN (?)", h.db.Model(&models.WebhookEvent{}).Select("id").Where("type = ?", eventType))
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		h.log(c).Error("Failed to count webhook deliveries:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to count webhook deliveries",
+		})
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Preload("Event").Limit(limit).Offset(offset).Order("id DESC").Find(&deliveries).Error; err != nil {
+		h.log(c).Error("Failed to list webhook deliveries:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to list webhook deliveries",
+		})
+		return
+	}
+	if !h.isPrivileged(c) {
+		for i := range deliveries {
+			deliveries[i].LastResponse = ""
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"deliveries": deliveries,
+			"total":      total,
+			"limit":      limit,
+			"offset":     offset,
+		},
+	})
+}
+
+// ReplayWebhook handles sending events to a webhook again. Each replayed
+// event gets a new delivery with a fresh retry budget; the original delivery
+// stays in the log unchanged.
+func (h *FileHandler) ReplayWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req ReplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid request body",
+		})
+		return
+	}
+	if len(req.DeliveryIDs) == 0 && req.Since == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "delivery_ids or since is required",
+		})
+		return
+	}
+	if len(req.DeliveryIDs) > maxReplayEvents {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("Too many deliveries: at most %d can be replayed at once", maxReplayEvents),
+		})
+		return
+	}
+
+	query := h.db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", webhook.ID)
+	if len(req.DeliveryIDs) > 0 {
+		query = query.Where("id IN ?", req.DeliveryIDs)
+	}
+	if req.Since != nil {
+		query = query.Where("created_at >= ?", *req.Since)
+	}
+	if req.Until != nil {
+		query = query.Where("created_at < ?", *req.Until)
+	}
+	if req.Status != "" {
+		query = query.Where("status = ?", req.Status)
+	}
+
+	var eventIDs []uint
+	if err := query.Distinct().Order("event_id").Limit(maxReplayEvents+1).Pluck("event_id", &eventIDs).Error; err != nil {
+		h.log(c).Error("Failed to select webhook deliveries:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to replay webhook deliveries",
+		})
+		return
+	}
+	if len(eventIDs) > maxReplayEvents {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": fmt.Sprintf("Too many events: at most %d can be replayed at once, narrow the time range", maxReplayEvents),
+		})
+		return
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(eventIDs))
+	now := time.Now()
+	for i, eventID := range eventIDs {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       eventID,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		}
+	}
+	if len(deliveries) > 0 {
+		if err := h.db.CreateInBatches(&deliveries, 100).Error; err != nil {
+			h.log(c).Error("Failed to queue webhook replay:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   true,
+				"message": "Failed to replay webhook deliveries",
+			})
+			return
+		}
+		h.webhooks.Notify()
+	}
+
+	h.log(c).Infof("Webhook replay queued: %d events (webhook ID: %d)", len(deliveries), webhook.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Replay queued",
+		"data": gin.H{
+			"deliveries": deliveries,
+			"queued":     len(deliveries),
+		},
+	})
+}
+
+// loadWebhook parses a webhook ID and loads it within the request's tenant,
+// writing the error response itself when it fails
+func (h *FileHandler) loadWebhook(c *gin.Context, idStr string) (*models.Webhook, bool) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   true,
+			"message": "Invalid webhook ID",
+		})
+		return nil, false
+	}
+
+	var webhook models.Webhook
+	if err := h.db.Where("tenant_id = ?", requestTenant(c)).First(&webhook, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":   true,
+				"message": "Webhook not found",
+			})
+			return nil, false
+		}
+		h.log(c).Error("Failed to get webhook:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   true,
+			"message": "Failed to get webhook",
+		})
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// applyWebhookRequest validates the fields set in req and copies them onto
+// webhook
+func applyWebhookRequest(webhook *models.Webhook, req *WebhookRequest) error {
+	if req.URL != nil {
+		target := strings.TrimSpace(*req.URL)
+		parsed, err := url.Parse(target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("Invalid url: must be an absolute http(s) URL")
+		}
+		webhook.URL = target
+	}
+
+	if req.Events != nil {
+		events := []string{}
+		seen := map[string]bool{}
+		for _, event := range req.Events {
+			event = strings.TrimSpace(event)
+			if !webhooks.IsEventType(event) {
+				return fmt.Errorf("Unknown event type: %s", event)
+			}
+			if !seen[event] {
+				seen[event] = true
+				events = append(events, event)
+			}
+		}
+		webhook.Events = events
+	}
+
+	if req.Description != nil {
+		webhook.Description = strings.TrimSpace(*req.Description)
+	}
+
+	if req.Secret != nil {
+		if len(*req.Secret) < 16 {
+			return fmt.Errorf("Invalid secret: must be at least 16 characters")
+		}
+		webhook.Secret = *req.Secret
+	}
+
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	return nil
+}
+
+// formatWebhook builds the public JSON representation of a webhook, without
+// its secret
+func formatWebhook(webhook models.Webhook) gin.H {
+	return gin.H{
+		"id":          webhook.ID,
+		"tenant_id":   webhook.TenantID,
+		"url":         webhook.URL,
+		"events":      webhook.Events,
+		"description": webhook.Description,
+		"active":      webhook.Active,
+		"created_at":  webhook.CreatedAt,
+		"updated_at":  webhook.UpdatedAt,
+	}
+}
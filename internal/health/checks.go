@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// errUnsupported is returned by diskSpace where free space can't be read
+var errUnsupported = errors.New("not supported on this platform")
+
+// Database checks a round trip to the database and reports the pool usage
+func Database(db *gorm.DB) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		var one int
+		if err := db.WithContext(ctx).Raw("SELECT 1").Scan(&one).Error; err != nil {
+			return nil, err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		stats := sqlDB.Stats()
+		return map[string]interface{}{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"max_open":         stats.MaxOpenConnections,
+		}, nil
+	}
+}
+
+// Storage checks that a file can be written to and removed from dir, and
+// reports the free space. Less than minFree bytes free (0 = no minimum)
+// fails the check.
+func Storage(dir string, minFree int64) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		probe, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return nil, err
+		}
+		_, err = probe.Write([]byte("ok"))
+		if closeErr := probe.Close(); err == nil {
+			err = closeErr
+		}
+		if removeErr := os.Remove(probe.Name()); err == nil {
+			err = removeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		details := map[string]interface{}{"writable": true}
+		free, total, err := diskSpace(dir)
+		if errors.Is(err, errUnsupported) {
+			return details, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		details["free_bytes"] = free
+		details["total_bytes"] = total
+		if minFree > 0 && free < uint64(minFree) {
+			return nil, fmt.Errorf("%d bytes free, below the %d bytes minimum", free, minFree)
+		}
+		return details, nil
+	}
+}
+
+// TCP checks that addr accepts connections
+func TCP(addr string) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn.Close()
+		return map[string]interface{}{"address": addr}, nil
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package health
+
+// diskSpace isn't implemented on this platform; free space isn't reported
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errUnsupported
+}
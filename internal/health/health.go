@@ -0,0 +1,180 @@
+// Package health runs the dependency checks behind the readiness and health
+// endpoints. Results are cached for a short while and concurrent callers
+// share a single run, so frequent probes don't hammer the database or disk.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Check states
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check is a named dependency check
+type Check struct {
+	Name string
+	// Critical checks make the service unready when they fail; the others
+	// only degrade the health report
+	Critical bool
+	// Timeout bounds one run; checks should honour ctx, but a run that
+	// doesn't is reported down once the timeout expires anyway
+	Timeout time.Duration
+	Run     func(ctx context.Context) (details map[string]interface{}, err error)
+}
+
+// Result is the outcome of one run of a check
+type Result struct {
+	Status    string                 `json:"status"`
+	Critical  bool                   `json:"critical"`
+	LatencyMs float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+// entry holds the cached result of a check and its run in progress
+type entry struct {
+	check Check
+
+	mu      sync.Mutex
+	result  Result
+	expires time.Time
+	running chan struct{} // closed when the current run finishes
+}
+
+// Checker runs registered checks, caching their results for ttl
+type Checker struct {
+	ttl     time.Duration
+	entries []*entry
+}
+
+// NewChecker creates a checker caching results for ttl (0 disables caching)
+func NewChecker(ttl time.Duration) *Checker {
+	return &Checker{ttl: ttl}
+}
+
+// Register adds a check. Checks must be registered before Run is called.
+func (c *Checker) Register(check Check) {
+	c.entries = append(c.entries, &entry{check: check})
+}
+
+// Run returns the results of the checks, by name. With criticalOnly set, only
+// the critical checks are run. A caller whose ctx ends first gets the check
+// reported down, while the run itself carries on for the next caller.
+func (c *Checker) Run(ctx context.Context, criticalOnly bool) map[string]Result {
+	results := make(map[string]Result, len(c.entries))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, e := range c.entries {
+		if criticalOnly && !e.check.Critical {
+			continue
+		}
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			result := c.get(ctx, e)
+			mu.Lock()
+			results[e.check.Name] = result
+			mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+	return results
+}
+
+// Healthy reports whether every critical check in results is up
+func Healthy(results map[string]Result) bool {
+	for _, result := range results {
+		if result.Critical && result.Status != StatusUp {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the cached result of e, running the check when it expired
+func (c *Checker) get(ctx context.Context, e *entry) Result {
+	e.mu.Lock()
+	if time.Now().Before(e.expires) {
+		result := e.result
+		e.mu.Unlock()
+		return result
+	}
+	if e.running == nil {
+		e.running = make(chan struct{})
+		go c.execute(e, e.running)
+	}
+	running := e.running
+	e.mu.Unlock()
+
+	select {
+	case <-running:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.result
+	case <-ctx.Done():
+		return Result{
+			Status:    StatusDown,
+			Critical:  e.check.Critical,
+			Error:     ctx.Err().Error(),
+			CheckedAt: time.Now(),
+		}
+	}
+}
+
+// execute runs the check of e and caches its result
+func (c *Checker) execute(e *entry, running chan struct{}) {
+	ctx := context.Background()
+	if e.check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.check.Timeout)
+		defer cancel()
+	}
+
+	type outcome struct {
+		details map[string]interface{}
+		err     error
+	}
+	start := time.Now()
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := e.check.Run(ctx)
+		done <- outcome{details, err}
+	}()
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+
+	result := Result{
+		Status:    StatusUp,
+		Critical:  e.check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err := out.err; err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", e.check.Timeout)
+		}
+		result.Status = StatusDown
+		result.Error = err.Error()
+	} else {
+		result.Details = out.details
+	}
+
+	e.mu.Lock()
+	e.result = result
+	e.expires = time.Now().Add(c.ttl)
+	e.running = nil
+	e.mu.Unlock()
+	close(running)
+}
@@ -0,0 +1,199 @@
+// Package imaging decodes, orients, resizes and encodes images using pure Go codecs
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/rwcarlsen/goexif/exif"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Ways a thumbnail can be fitted into the requested box
+const (
+	FitContain = "contain" // scale down to fit inside the box, keeping the aspect ratio
+	FitCover   = "cover"   // fill the box, cropping the overflow around the center
+	FitFill    = "fill"    // stretch to the box, ignoring the aspect ratio
+)
+
+// JPEGQuality is the quality used when encoding JPEG renditions
+const JPEGQuality = 85
+
+var (
+	// ErrUnsupported is returned for content that isn't a JPEG, PNG, GIF or WebP image
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images whose dimensions exceed the pixel limit
+	ErrTooLarge = errors.New("image dimensions exceed the allowed limit")
+)
+
+// Image is a decoded image together with its source format and EXIF orientation
+type Image struct {
+	image.Image
+	Format      string
+	Orientation int
+}
+
+// Load decodes the image at path. The dimensions are checked against
+// maxPixels (0 = unlimited) before the pixel data is decoded, so oversized
+// images are refused without allocating memory for them.
+func Load(path string, maxPixels int64) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupported
+		}
+		return nil, err
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			orientation = Orientation(f)
+		}
+	}
+
+	return &Image{Image: img, Format: format, Orientation: orientation}, nil
+}
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG, or 1 when it has none
+func Orientation(r io.Reader) int {
+	x, err := exif.Decode(r)
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	value, err := tag.Int(0)
+	if err != nil || value < 1 || value > 8 {
+		return 1
+	}
+	return value
+}
+
+// Thumbnail resizes src to fit width x height (in display orientation) using
+// fit, then applies the EXIF orientation. A zero width or height scales
+// proportionally to the other one. Images are never upscaled.
+func Thumbnail(src *Image, width, height int, fit string) image.Image {
+	// Orientations 5-8 swap the axes, so resize against the stored layout
+	if src.Orientation >= 5 {
+		width, height = height, width
+	}
+
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	crop := bounds
+
+	switch {
+	case width == 0 && height == 0:
+		width, height = sw, sh
+	case width == 0:
+		width = max(1, sw*height/sh)
+	case height == 0:
+		height = max(1, sh*width/sw)
+	case fit == FitCover:
+		// Crop the source to the box's aspect ratio around the center
+		if sw*height > sh*width {
+			cw := sh * width / height
+			crop = image.Rect(bounds.Min.X+(sw-cw)/2, bounds.Min.Y, bounds.Min.X+(sw-cw)/2+cw, bounds.Max.Y)
+		} else {
+			ch := sw * height / width
+			crop = image.Rect(bounds.Min.X, bounds.Min.Y+(sh-ch)/2, bounds.Max.X, bounds.Min.Y+(sh-ch)/2+ch)
+		}
+	case fit == FitFill:
+	default:
+		if sw*height > sh*width {
+			height = max(1, sh*width/sw)
+		} else {
+			width = max(1, sw*height/sh)
+		}
+	}
+
+	if width > crop.Dx() || height > crop.Dy() {
+		width, height = crop.Dx(), crop.Dy()
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src.Image, crop, xdraw.Src, nil)
+	return Orient(dst, src.Orientation)
+}
+
+// Orient transforms img so that it displays upright for the given EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// Encode writes img as "jpeg" or "png". JPEG has no alpha channel, so
+// transparent areas are flattened onto white.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		return jpeg.Encode(w, flat, &jpeg.Options{Quality: JPEGQuality})
+	case "png":
+		return png.Encode(w, img)
+	}
+	return ErrUnsupported
+}
@@ -0,0 +1,302 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrMalformed is returned when an image's container structure can't be parsed
+var ErrMalformed = errors.New("malformed image")
+
+// StripFormat returns the format StripMetadata handles ("jpeg", "png" or
+// "webp") that the image in r is in, judged by its signature rather than its
+// name, or "" for anything else
+func StripFormat(r io.ReaderAt) string {
+	header := make([]byte, 12)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(header) == 12 && string(header[:4]) == "RIFF" && string(header[8:]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// StripMetadata copies the image in r (size bytes) to w without its EXIF,
+// XMP, IPTC and text metadata. Only metadata segments are dropped, so the
+// pixel data is kept byte for byte. A JPEG's EXIF orientation is carried over
+// in a minimal EXIF block so the image still displays upright. It reports
+// whether anything was removed.
+func StripMetadata(w io.Writer, r io.ReaderAt, size int64, format string) (bool, error) {
+	switch format {
+	case "jpeg":
+		return stripJPEG(w, io.NewSectionReader(r, 0, size))
+	case "png":
+		return stripPNG(w, io.NewSectionReader(r, 0, size))
+	case "webp":
+		return stripWebP(w, r, size)
+	}
+	return false, ErrUnsupported
+}
+
+// stripJPEG drops APP1 (EXIF/XMP), APP13 (IPTC), comments and unknown APPn
+// segments, keeping JFIF, ICC profiles and the Adobe color transform marker
+func stripJPEG(w io.Writer, r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(br, soi); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return false, ErrMalformed
+	}
+	if _, err := w.Write(soi); err != nil {
+		return false, err
+	}
+
+	stripped := false
+	orientation := 1
+	orientationWritten := false
+	for {
+		marker, err := readJPEGMarker(br)
+		if err != nil {
+			return false, err
+		}
+
+		// Standalone markers carry no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		var segment []byte
+		if marker != 0xD9 {
+			length := make([]byte, 2)
+			if _, err := io.ReadFull(br, length); err != nil {
+				return false, ErrMalformed
+			}
+			n := int(binary.BigEndian.Uint16(length))
+			if n < 2 {
+				return false, ErrMalformed
+			}
+			segment = make([]byte, n-2)
+			if _, err := io.ReadFull(br, segment); err != nil {
+				return false, ErrMalformed
+			}
+		}
+
+		if !keepJPEGSegment(marker, segment) {
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				orientation = Orientation(bytes.NewReader(segment[6:]))
+			}
+			stripped = true
+			continue
+		}
+
+		// The orientation goes right after the JFIF header, ahead of
+		// everything else
+		if marker != 0xE0 && !orientationWritten {
+			orientationWritten = true
+			if orientation > 1 {
+				if _, err := w.Write(orientationSegment(orientation)); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+			return false, err
+		}
+		if marker == 0xD9 {
+			return stripped, nil
+		}
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(segment)+2))
+		if _, err := w.Write(length); err != nil {
+			return false, err
+		}
+		if _, err := w.Write(segment); err != nil {
+			return false, err
+		}
+
+		// Entropy-coded data follows the start of scan; the rest of the file
+		// is image data (and possibly further scans) that is copied as is
+		if marker == 0xDA {
+			if _, err := io.Copy(w, br); err != nil {
+				return false, err
+			}
+			return stripped, nil
+		}
+	}
+}
+
+// readJPEGMarker reads the next marker, skipping fill bytes
+func readJPEGMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, ErrMalformed
+	}
+	for {
+		b, err = br.ReadByte()
+		if err != nil {
+			return 0, ErrMalformed
+		}
+		if b != 0xFF {
+			return b, nil
+		}
+	}
+}
+
+// keepJPEGSegment reports whether a segment is needed to render the image
+func keepJPEGSegment(marker byte, segment []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return bytes.HasPrefix(segment, []byte("JFIF\x00"))
+	case marker == 0xE2:
+		return bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(segment, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+// orientationSegment builds an APP1 segment whose EXIF block holds nothing
+// but the orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, // big-endian TIFF header
+		0x00, 0x00, 0x00, 0x08, // offset of IFD0
+		0x00, 0x01, // one entry
+		0x01, 0x12, // Orientation
+		0x00, 0x03, // SHORT
+		0x00, 0x00, 0x00, 0x01, // count
+		0x00, byte(orientation), 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1, 0x00, 0x00}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngMetadataChunks are the ancillary chunks removed from PNG files
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true, // includes XMP packets
+	"tIME": true,
+}
+
+// stripPNG drops the metadata chunks of a PNG, copying every other chunk
+// (CRC included) unchanged
+func stripPNG(w io.Writer, r io.Reader) (bool, error) {
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil || string(signature) != "\x89PNG\r\n\x1a\n" {
+		return false, ErrMalformed
+	}
+	if _, err := w.Write(signature); err != nil {
+		return false, err
+	}
+
+	stripped := false
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return false, ErrMalformed
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:8])
+
+		if pngMetadataChunks[chunkType] {
+			// Skip the data and the CRC
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return false, ErrMalformed
+			}
+			stripped = true
+			continue
+		}
+
+		if _, err := w.Write(header); err != nil {
+			return false, err
+		}
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return false, ErrMalformed
+		}
+		if chunkType == "IEND" {
+			return stripped, nil
+		}
+	}
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP file and clears their
+// flags in the VP8X header. The RIFF size is written up front, so the chunk
+// list is scanned once before copying.
+func stripWebP(w io.Writer, r io.ReaderAt, size int64) (bool, error) {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return false, ErrMalformed
+	}
+
+	type chunk struct {
+		fourCC string
+		offset int64 // of the chunk header
+		size   int64 // including header and padding
+	}
+	var chunks []chunk
+	riffSize := int64(4)
+	stripped := false
+	chunkHeader := make([]byte, 8)
+	for offset := int64(12); offset+8 <= size; {
+		if _, err := r.ReadAt(chunkHeader, offset); err != nil {
+			return false, ErrMalformed
+		}
+		payload := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		total := 8 + payload + payload%2
+		if offset+8+payload > size {
+			return false, ErrMalformed
+		}
+
+		fourCC := string(chunkHeader[:4])
+		if fourCC == "EXIF" || fourCC == "XMP " {
+			stripped = true
+		} else {
+			chunks = append(chunks, chunk{fourCC: fourCC, offset: offset, size: min(total, size-offset)})
+			riffSize += min(total, size-offset)
+		}
+		offset += total
+	}
+
+	binary.LittleEndian.PutUint32(header[4:8], uint32(riffSize))
+	if _, err := w.Write(header); err != nil {
+		return false, err
+	}
+
+	for _, c := range chunks {
+		if c.fourCC == "VP8X" && c.size >= 9 {
+			data := make([]byte, c.size)
+			if _, err := r.ReadAt(data, c.offset); err != nil {
+				return false, ErrMalformed
+			}
+			// Flags byte: 0x08 = EXIF present, 0x04 = XMP present
+			data[8] &^= 0x08 | 0x04
+			if _, err := w.Write(data); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(r, c.offset, c.size)); err != nil {
+			return false, err
+		}
+	}
+	return stripped, nil
+}
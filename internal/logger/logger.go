@@ -2,12 +2,15 @@ package logger
 
 import (
 	"os"
+
 	"github.com/sirupsen/logrus"
 )
 
-func New(level string) *logrus.Logger {
+// New creates the application logger. format is "json" for one JSON object
+// per line, anything else for human-readable text.
+func New(level, format string) *logrus.Logger {
 	log := logrus.New()
-	
+
 	// Set log level
 	switch level {
 	case "debug":
@@ -21,15 +24,24 @@ func New(level string) *logrus.Logger {
 	default:
 		log.SetLevel(logrus.InfoLevel)
 	}
-	
+
 	// Set output
 	log.SetOutput(os.Stdout)
-	
+
 	// Set formatter
-	log.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
-	
+	if format == "json" {
+		log.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		})
+	} else {
+		log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	}
+
+	// Entries logged with a request's context carry its fields
+	log.AddHook(RequestHook{})
+
 	return log
 }
@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type requestKey struct{}
+
+// request holds the fields of a request added to every entry logged with
+// its context
+type request struct {
+	fields logrus.Fields
+	start  time.Time
+}
+
+// WithRequest returns ctx carrying fields (request ID, route, tenant...) for
+// the entries logged with it, along with their latency since now
+func WithRequest(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{fields: fields, start: time.Now()})
+}
+
+// RequestID returns the request ID stored in ctx by WithRequest
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		id, _ := req.fields["request_id"].(string)
+		return id
+	}
+	return ""
+}
+
+// Latency returns the time elapsed since WithRequest
+func Latency(ctx context.Context) time.Duration {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return time.Since(req.start)
+	}
+	return 0
+}
+
+// RequestHook adds the request fields and latency to entries logged with a
+// request's context (logger.WithContext). Fields set on the entry itself win.
+type RequestHook struct{}
+
+func (RequestHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RequestHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	req, ok := entry.Context.Value(requestKey{}).(*request)
+	if !ok {
+		return nil
+	}
+	for key, value := range req.fields {
+		if _, set := entry.Data[key]; !set {
+			entry.Data[key] = value
+		}
+	}
+	if _, set := entry.Data["latency_ms"]; !set {
+		entry.Data["latency_ms"] = time.Since(req.start).Milliseconds()
+	}
+	return nil
+}
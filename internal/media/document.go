@@ -0,0 +1,23 @@
+package media
+
+import (
+	"api-file-upload-go/internal/models"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func init() {
+	Register("application/pdf", Extractor{Name: "pdf", Async: true, Extract: extractPDF})
+}
+
+// extractPDF reads the page count from the document's page tree
+func extractPDF(path string, info *models.MediaInfo) error {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info.Pages = r.NumPage()
+	return nil
+}
@@ -0,0 +1,185 @@
+package media
+
+import (
+	"api-file-upload-go/internal/models"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/tcolgate/mp3"
+)
+
+func init() {
+	for _, mimeType := range []string{"video/mp4", "video/quicktime", "audio/mp4", "audio/x-m4a"} {
+		Register(mimeType, Extractor{Name: "mp4", Extract: extractMP4Duration})
+	}
+	for _, mimeType := range []string{"audio/wav", "audio/x-wav", "audio/wave", "audio/vnd.wave"} {
+		Register(mimeType, Extractor{Name: "wav", Extract: extractWAVDuration})
+	}
+	Register("audio/mpeg", Extractor{Name: "mp3", Async: true, Extract: extractMP3Duration})
+}
+
+var errNoDuration = errors.New("duration not found")
+
+// extractMP4Duration reads the duration from the movie header ("mvhd" inside
+// "moov"), seeking over the media data so only the headers are read
+func extractMP4Duration(path string, info *models.MediaInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	moovStart, moovEnd, err := findBox(f, 0, stat.Size(), "moov")
+	if err != nil {
+		return err
+	}
+	mvhdStart, _, err := findBox(f, moovStart, moovEnd, "mvhd")
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 32)
+	if _, err := f.ReadAt(header, mvhdStart); err != nil {
+		return err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if header[0] == 1 {
+		// version 1: 64-bit creation/modification times and duration
+		timescale = binary.BigEndian.Uint32(header[20:24])
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(header[12:16])
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return errNoDuration
+	}
+
+	info.Duration = float64(duration) / float64(timescale)
+	return nil
+}
+
+// findBox returns the content range of the first box of type name between
+// start and end
+func findBox(r io.ReaderAt, start, end int64, name string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// Box extends to the end of its container
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return 0, 0, errors.New("malformed box")
+		}
+
+		if string(header[4:8]) == name {
+			return offset + headerSize, offset + size, nil
+		}
+		offset += size
+	}
+	return 0, 0, errNoDuration
+}
+
+// extractWAVDuration computes the duration from the "fmt " byte rate and
+// the size of the "data" chunk
+func extractWAVDuration(path string, info *models.MediaInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return errors.New("not a WAVE file")
+	}
+
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return errNoDuration
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		// Chunks are padded to an even size
+		skip := size + size%2
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			format := make([]byte, 16)
+			if size < 16 {
+				return errors.New("malformed fmt chunk")
+			}
+			if _, err := io.ReadFull(f, format); err != nil {
+				return err
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+			skip -= 16
+		case "data":
+			if byteRate == 0 {
+				return errNoDuration
+			}
+			info.Duration = float64(size) / float64(byteRate)
+			return nil
+		}
+
+		if _, err := f.Seek(skip, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+}
+
+// extractMP3Duration adds up the duration of every MPEG audio frame, which
+// is the only reliable way for variable bit rate files
+func extractMP3Duration(path string, info *models.MediaInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := mp3.NewDecoder(bufio.NewReader(f))
+	var frame mp3.Frame
+	var skipped int
+	var total float64
+	for {
+		if err := decoder.Decode(&frame, &skipped); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		total += frame.Duration().Seconds()
+	}
+	if total == 0 {
+		return errNoDuration
+	}
+
+	info.Duration = total
+	return nil
+}
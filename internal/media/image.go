@@ -0,0 +1,75 @@
+package media
+
+import (
+	"api-file-upload-go/internal/models"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/webp"
+)
+
+func init() {
+	for _, mimeType := range []string{"image/jpeg", "image/png", "image/gif", "image/webp"} {
+		Register(mimeType, Extractor{Name: "image", Extract: extractImage})
+	}
+}
+
+// extractImage reads the dimensions from the image header and, for JPEGs,
+// the capture date and camera from EXIF. Dimensions are reported as displayed,
+// with the EXIF orientation applied.
+func extractImage(path string, info *models.MediaInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+
+	if format != "jpeg" {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		// Plenty of JPEGs carry no EXIF at all
+		return nil
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orientation, err := tag.Int(0); err == nil && orientation >= 5 && orientation <= 8 {
+			info.Width, info.Height = info.Height, info.Width
+		}
+	}
+	if taken, err := x.DateTime(); err == nil {
+		info.TakenAt = taken.Format("2006-01-02T15:04:05")
+	}
+	info.CameraMake = exifString(x, exif.Make)
+	info.CameraModel = exifString(x, exif.Model)
+	return nil
+}
+
+// exifString returns a trimmed string tag, or "" when it is missing
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
@@ -0,0 +1,76 @@
+// Package media extracts technical metadata (dimensions, capture details,
+// page count, duration) from stored files through per-MIME extractors
+package media
+
+import (
+	"api-file-upload-go/internal/models"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Extractor reads one kind of file and fills in the fields it knows about
+type Extractor struct {
+	Name string
+	// Async marks extractors that have to read the whole file; they run in
+	// the background instead of delaying the upload response
+	Async   bool
+	Extract func(path string, info *models.MediaInfo) error
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string][]Extractor{}
+)
+
+// Register adds an extractor for mimeType, which is either an exact type
+// ("application/pdf") or a whole family ("image/*")
+func Register(mimeType string, extractor Extractor) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[mimeType] = append(registry[mimeType], extractor)
+}
+
+// Extractors returns the extractors registered for mimeType, split into the
+// ones to run right away and the ones to run in the background
+func Extractors(mimeType string) (inline, background []Extractor) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	candidates := append([]Extractor{}, registry[mimeType]...)
+	if i := strings.Index(mimeType, "/"); i > 0 {
+		candidates = append(candidates, registry[mimeType[:i]+"/*"]...)
+	}
+
+	for _, extractor := range candidates {
+		if extractor.Async {
+			background = append(background, extractor)
+		} else {
+			inline = append(inline, extractor)
+		}
+	}
+	return inline, background
+}
+
+// Run applies extractors to the file at path in order. Every extractor runs
+// even if an earlier one fails; the failures are returned together.
+func Run(extractors []Extractor, path string, info *models.MediaInfo) []error {
+	var errs []error
+	for _, extractor := range extractors {
+		if err := safeExtract(extractor, path, info); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", extractor.Name, err))
+		}
+	}
+	return errs
+}
+
+// safeExtract keeps a panicking parser (malformed input) from taking the
+// process down
+func safeExtract(extractor Extractor, path string, info *models.MediaInfo) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return extractor.Extract(path, info)
+}
@@ -0,0 +1,265 @@
+// Package metrics exposes the API's Prometheus metrics: HTTP requests by
+// route template, upload and download sizes and durations, dedup hits,
+// upload rejections, storage errors, rate-limited requests, configuration
+// reloads, database pool stats and active uploads.
+package metrics
+
+import (
+	"api-file-upload-go/internal/progress"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fileapi"
+
+// Upload rejection reasons
+const (
+	RejectExtension       = "extension"
+	RejectFileTooLarge    = "file_too_large"
+	RejectRequestTooLarge = "request_too_large"
+	RejectTooManyFiles    = "too_many_files"
+	RejectInvalidBody     = "invalid_body"
+	RejectInvalidOptions  = "invalid_options"
+	RejectNameConflict    = "name_conflict"
+	RejectStalled         = "stalled"
+	RejectTooSlow         = "too_slow"
+	RejectShutdown        = "shutdown"
+)
+
+// Storage operations
+const (
+	OpWrite  = "write"
+	OpRead   = "read"
+	OpDelete = "delete"
+)
+
+// Rate limit reasons
+const (
+	LimitRate        = "rate"
+	LimitConcurrency = "concurrency"
+	LimitQuota       = "quota"
+	LimitEgress      = "egress"
+)
+
+// Configuration reload results
+const (
+	ReloadApplied  = "applied"
+	ReloadRejected = "rejected"
+)
+
+// unmatchedRoute labels requests that matched no route, so unknown paths
+// don't create a series each
+const unmatchedRoute = "unmatched"
+
+// sizeBuckets go from 1KB to 4GB
+var sizeBuckets = prometheus.ExponentialBuckets(1<<10, 4, 12)
+
+// durationBuckets go from 10ms to about 20 minutes
+var durationBuckets = prometheus.ExponentialBuckets(0.01, 3, 12)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	uploadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_size_bytes",
+		Help:      "Bytes received per upload request.",
+		Buckets:   sizeBuckets,
+	}, []string{"route"})
+
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Duration of upload requests.",
+		Buckets:   durationBuckets,
+	}, []string{"route"})
+
+	downloadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_size_bytes",
+		Help:      "Bytes sent per successful download.",
+		Buckets:   sizeBuckets,
+	}, []string{"route"})
+
+	downloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of successful downloads.",
+		Buckets:   durationBuckets,
+	}, []string{"route"})
+
+	dedupHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_hits_total",
+		Help:      "Uploads whose content matched an existing file.",
+	})
+
+	uploadRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_rejections_total",
+		Help:      "Uploaded files rejected by validation, by reason.",
+	}, []string{"reason"})
+
+	storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Failed storage operations, by operation.",
+	}, []string{"operation"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests refused by the rate limiter, by request class and limit reached.",
+	}, []string{"class", "reason"})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		uploadSize,
+		uploadDuration,
+		downloadSize,
+		downloadDuration,
+		dedupHits,
+		uploadRejections,
+		storageErrors,
+		rateLimited,
+		configReloads,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times every request, labelled with the matched route
+// template (e.g. /api/v1/files/:id) rather than the raw path
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := routeLabel(c)
+		requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		requestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// TrackDownload is the middleware recording the size and duration of
+// successful downloads on the routes it is added to
+func TrackDownload(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return
+	}
+	route := routeLabel(c)
+	downloadSize.WithLabelValues(route).Observe(float64(max(c.Writer.Size(), 0)))
+	downloadDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+}
+
+// ObserveUpload records the bytes received by an upload request and how long
+// it took
+func ObserveUpload(route string, bytes int64, duration time.Duration) {
+	uploadSize.WithLabelValues(route).Observe(float64(bytes))
+	uploadDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+// DedupHit records an upload matching the content of an existing file
+func DedupHit() {
+	dedupHits.Inc()
+}
+
+// UploadRejected records a file rejected for reason (one of the Reject
+// constants)
+func UploadRejected(reason string) {
+	uploadRejections.WithLabelValues(reason).Inc()
+}
+
+// StorageError records a failed storage operation (one of the Op constants)
+func StorageError(operation string) {
+	storageErrors.WithLabelValues(operation).Inc()
+}
+
+// RateLimited records a request of class refused for reason (one of the
+// Limit constants)
+func RateLimited(class, reason string) {
+	rateLimited.WithLabelValues(class, reason).Inc()
+}
+
+// ConfigReloaded records a configuration reload (one of the Reload
+// constants)
+func ConfigReloaded(result string) {
+	configReloads.WithLabelValues(result).Inc()
+}
+
+// RegisterDB exposes the connection pool stats of db
+func RegisterDB(db *sql.DB, name string) error {
+	return registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterUploads exposes the number of uploads in progress in uploads
+func RegisterUploads(uploads *progress.Registry) error {
+	return registry.Register(&uploadsCollector{uploads: uploads})
+}
+
+func routeLabel(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
+
+// uploadsCollector reads the active upload counts at scrape time
+type uploadsCollector struct {
+	uploads *progress.Registry
+}
+
+var uploadsActiveDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "uploads_active"),
+	"Uploads in progress, by status.",
+	[]string{"status"}, nil,
+)
+
+func (u *uploadsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- uploadsActiveDesc
+}
+
+func (u *uploadsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := u.uploads.Active()
+	// Report both states, so they read 0 instead of disappearing when idle
+	for _, status := range []string{progress.StatusReceiving, progress.StatusProcessing} {
+		ch <- prometheus.MustNewConstMetric(uploadsActiveDesc, prometheus.GaugeValue, float64(counts[status]), status)
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records a change made through the API. Details holds the
+// action-specific payload (e.g. the fields changed with their old/new values).
+type AuditLog struct {
+	ID           uint                   `json:"id" gorm:"primaryKey"`
+	Action       string                 `json:"action" gorm:"index;not null"`
+	ResourceType string                 `json:"resource_type" gorm:"index:idx_audit_logs_resource;not null"`
+	ResourceID   uint                   `json:"resource_id" gorm:"index:idx_audit_logs_resource"`
+	Actor        string                 `json:"actor"`
+	Details      map[string]interface{} `json:"details" gorm:"serializer:json"`
+	CreatedAt    time.Time              `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
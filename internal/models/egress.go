@@ -0,0 +1,16 @@
+package models
+
+// EgressUsage counts the bytes of a file a tenant downloaded on one day
+// (UTC), directly or within archives, for egress accounting and the monthly
+// egress quota
+type EgressUsage struct {
+	TenantID  string `json:"tenant_id" gorm:"primaryKey"`
+	Day       string `json:"day" gorm:"primaryKey;size:10"` // YYYY-MM-DD
+	FileID    uint   `json:"file_id" gorm:"primaryKey"`
+	Bytes     int64  `json:"bytes" gorm:"not null"`
+	Downloads int64  `json:"downloads" gorm:"not null"`
+}
+
+func (EgressUsage) TableName() string {
+	return "egress_usage"
+}
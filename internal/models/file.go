@@ -1,22 +1,39 @@
 package models
 
 import (
-	"time"
 	"gorm.io/gorm"
+	"time"
+)
+
+// File visibility values
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
 )
 
 type File struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	Name        string    `json:"name" gorm:"not null"`
-	OriginalName string   `json:"original_name" gorm:"not null"`
-	Path        string    `json:"path" gorm:"not null"`
-	Size        int64     `json:"size" gorm:"not null"`
-	MimeType    string    `json:"mime_type" gorm:"not null"`
-	Extension   string    `json:"extension" gorm:"not null"`
-	Hash        string    `json:"hash" gorm:"uniqueIndex;not null"`
-	UploadedAt  time.Time `json:"uploaded_at" gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID             uint              `json:"id" gorm:"primaryKey"`
+	Name           string            `json:"name" gorm:"not null"`
+	OriginalName   string            `json:"original_name" gorm:"not null"`
+	Path           string            `json:"path" gorm:"not null"`
+	Size           int64             `json:"size" gorm:"not null"`
+	MimeType       string            `json:"mime_type" gorm:"not null"`
+	Extension      string            `json:"extension" gorm:"not null"`
+	Hash           string            `json:"hash" gorm:"not null"`
+	FolderID       *uint             `json:"folder_id" gorm:"index"`
+	VirtualPath    string            `json:"virtual_path" gorm:"index"`
+	CurrentVersion int               `json:"current_version" gorm:"not null;default:1"`
+	Metadata       map[string]string `json:"metadata" gorm:"serializer:json"`
+	Tags           []string          `json:"tags" gorm:"serializer:json"`
+	Visibility     string            `json:"visibility" gorm:"not null;default:private"`
+	Revision       int               `json:"revision" gorm:"not null;default:1"`
+	MediaInfo      *MediaInfo        `json:"media_info" gorm:"type:jsonb;serializer:json"`
+	MediaStatus    string            `json:"media_status"`
+	OriginalPath   string            `json:"-"`                           // unsanitized upload, when retained
+	ContentText    string            `json:"-" gorm:"type:text;->:false"` // extracted for search; write-only so listings don't load it
+	UploadedAt     time.Time         `json:"uploaded_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time         `json:"updated_at" gorm:"autoUpdateTime"`
+	DeletedAt      gorm.DeletedAt    `json:"deleted_at" gorm:"index"`
 }
 
 func (File) TableName() string {
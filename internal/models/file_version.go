@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// FileVersion is one stored revision of a file's content. The File row always
+// mirrors the version referenced by File.CurrentVersion.
+type FileVersion struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	FileID     uint   `json:"file_id" gorm:"uniqueIndex:idx_file_versions_file_version;not null"`
+	Version    int    `json:"version" gorm:"uniqueIndex:idx_file_versions_file_version;not null"`
+	Name       string `json:"name" gorm:"not null"`
+	Path       string `json:"path" gorm:"not null"`
+	Size       int64  `json:"size" gorm:"not null"`
+	MimeType   string `json:"mime_type" gorm:"not null"`
+	Hash       string `json:"hash" gorm:"index;not null"`
+	UploadedBy string `json:"uploaded_by"`
+	// OriginalPath is the unsanitized upload, when metadata was stripped and
+	// originals are retained
+	OriginalPath string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (FileVersion) TableName() string {
+	return "file_versions"
+}
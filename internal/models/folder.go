@@ -0,0 +1,22 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Folder is a node in the virtual folder hierarchy. Path is the full virtual
+// path of the folder (e.g. "/reports/2024") and is kept in sync on rename/move.
+type Folder struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null"`
+	ParentID  *uint          `json:"parent_id" gorm:"index"`
+	Path      string         `json:"path" gorm:"index;not null"`
+	CreatedAt time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `json:"updated_at" gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+func (Folder) TableName() string {
+	return "folders"
+}
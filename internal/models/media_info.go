@@ -0,0 +1,20 @@
+package models
+
+// Media extraction states of a file
+const (
+	MediaStatusPending = "pending"
+	MediaStatusReady   = "ready"
+	MediaStatusFailed  = "failed"
+)
+
+// MediaInfo is the technical metadata extracted from a file's content. Only
+// the fields that apply to the file's type are set.
+type MediaInfo struct {
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	TakenAt     string  `json:"taken_at,omitempty"` // camera local time, 2006-01-02T15:04:05
+	CameraMake  string  `json:"camera_make,omitempty"`
+	CameraModel string  `json:"camera_model,omitempty"`
+	Pages       int     `json:"pages,omitempty"`
+	Duration    float64 `json:"duration,omitempty"` // seconds
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitBucket is a token bucket shared by the API instances when rate
+// limits are kept in the database
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+	FullAt    time.Time `gorm:"not null;index"` // when the bucket refills and can be forgotten
+}
+
+func (RateLimitBucket) TableName() string {
+	return "rate_limit_buckets"
+}
+
+// BandwidthUsage counts the bytes a principal uploaded and downloaded on one
+// day (UTC), for the daily bandwidth quota
+type BandwidthUsage struct {
+	Key   string `gorm:"primaryKey"`
+	Day   string `gorm:"primaryKey;size:10"` // YYYY-MM-DD
+	Bytes int64  `gorm:"not null"`
+}
+
+func (BandwidthUsage) TableName() string {
+	return "bandwidth_usage"
+}
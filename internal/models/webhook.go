@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook event types
+const (
+	EventFileUploaded  = "file.uploaded"
+	EventFileVersioned = "file.versioned"
+	EventFileDeleted   = "file.deleted"
+	EventFileRestored  = "file.restored"
+	EventFileScanned   = "file.scanned"
+	EventFileExpired   = "file.expired"
+)
+
+// WebhookEventTypes lists the event types a webhook can subscribe to
+var WebhookEventTypes = []string{
+	EventFileUploaded,
+	EventFileVersioned,
+	EventFileDeleted,
+	EventFileRestored,
+	EventFileScanned,
+	EventFileExpired,
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a tenant's subscription to file lifecycle events. Secret signs
+// every payload sent to URL and is only shown when the webhook is created.
+type Webhook struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	TenantID    string         `json:"tenant_id" gorm:"index;not null"`
+	URL         string         `json:"url" gorm:"not null"`
+	Secret      string         `json:"-" gorm:"not null"`
+	Events      []string       `json:"events" gorm:"serializer:json"`
+	Description string         `json:"description"`
+	Active      bool           `json:"active" gorm:"not null;default:true"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time      `json:"updated_at" gorm:"autoUpdateTime"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Subscribes reports whether the webhook wants events of eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is an outbox entry. It is written in the same transaction as
+// the change it describes and fanned out to the tenant's webhooks afterwards;
+// DispatchedAt is set once the deliveries have been created.
+type WebhookEvent struct {
+	ID           uint                   `json:"id" gorm:"primaryKey"`
+	TenantID     string                 `json:"tenant_id" gorm:"index;not null"`
+	Type         string                 `json:"type" gorm:"not null"`
+	Data         map[string]interface{} `json:"data" gorm:"serializer:json"`
+	CreatedAt    time.Time              `json:"created_at" gorm:"autoCreateTime"`
+	DispatchedAt *time.Time             `json:"dispatched_at" gorm:"index"`
+}
+
+func (WebhookEvent) TableName() string {
+	return "webhook_events"
+}
+
+// WebhookDelivery tracks sending one event to one webhook, with the outcome
+// of the latest attempt
+type WebhookDelivery struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	WebhookID      uint          `json:"webhook_id" gorm:"index;not null"`
+	EventID        uint          `json:"event_id" gorm:"index;not null"`
+	Event          *WebhookEvent `json:"event,omitempty"`
+	Status         string        `json:"status" gorm:"index:idx_webhook_deliveries_due;not null"`
+	Attempts       int           `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt  time.Time     `json:"next_attempt_at" gorm:"index:idx_webhook_deliveries_due"`
+	LastStatusCode int           `json:"last_status_code"`
+	LastError      string        `json:"last_error"`
+	LastResponse   string        `json:"last_response"`
+	LastDurationMs int64         `json:"last_duration_ms"`
+	DeliveredAt    *time.Time    `json:"delivered_at"`
+	CreatedAt      time.Time     `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time     `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -0,0 +1,401 @@
+// Package progress keeps a registry of in-flight uploads: how many bytes
+// have been received out of how many expected, when and by whom they were
+// started. Uploads that stop sending data for longer than the idle timeout,
+// or send it slower than the minimum rate, are cancelled.
+package progress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upload states
+const (
+	StatusReceiving  = "receiving"  // request body still arriving
+	StatusProcessing = "processing" // body received, files being stored
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled" // stalled, too slow, or shut down
+)
+
+const (
+	// reapInterval is how often stalled and finished uploads are checked
+	reapInterval = time.Second
+	// finishedRetention is how long finished uploads stay visible, so
+	// clients polling for progress see the outcome
+	finishedRetention = time.Minute
+	// waitInterval is how often Wait checks for unfinished uploads
+	waitInterval = 50 * time.Millisecond
+)
+
+var (
+	// ErrInUse is returned by Begin when the ID belongs to an upload in progress
+	ErrInUse = errors.New("upload ID already in use")
+	// ErrCancelled is wrapped by the errors returned when reading the body of
+	// a cancelled upload
+	ErrCancelled = errors.New("upload cancelled")
+	// ErrStalled is returned for uploads cancelled after the idle timeout
+	ErrStalled = fmt.Errorf("%w: stalled", ErrCancelled)
+	// ErrTooSlow is returned for uploads cancelled below the minimum rate
+	ErrTooSlow = fmt.Errorf("%w: too slow", ErrCancelled)
+	// ErrShutdown is returned for uploads cancelled by CancelAll
+	ErrShutdown = fmt.Errorf("%w: server shutting down", ErrCancelled)
+)
+
+// Upload describes a request registered with Begin
+type Upload struct {
+	ID            string
+	TenantID      string
+	Client        string
+	Path          string
+	ExpectedBytes int64 // -1 when unknown
+}
+
+// Snapshot is the state of an upload at one point in time
+type Snapshot struct {
+	ID             string     `json:"id"`
+	TenantID       string     `json:"tenant_id"`
+	Client         string     `json:"client"`
+	Path           string     `json:"path"`
+	Status         string     `json:"status"`
+	BytesReceived  int64      `json:"bytes_received"`
+	ExpectedBytes  int64      `json:"expected_bytes"`
+	Percent        *float64   `json:"percent"`
+	BytesPerSecond float64    `json:"bytes_per_second"`
+	StartedAt      time.Time  `json:"started_at"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+}
+
+// Tracker follows one upload
+type Tracker struct {
+	upload    Upload
+	startedAt time.Time
+	cancel    func()
+
+	received     atomic.Int64
+	lastActivity atomic.Int64 // UnixNano
+	cancelErr    atomic.Pointer[error]
+
+	// Start of the current rate window and bytes received by then, only
+	// used by the registry's checks
+	windowStart time.Time
+	windowBytes int64
+
+	mu             sync.Mutex
+	status         string
+	finishedAt     time.Time
+	responseStatus int
+}
+
+// Wrap returns body counting the bytes read through it. Once the upload is
+// cancelled, reads fail with ErrStalled, ErrTooSlow or ErrShutdown.
+func (t *Tracker) Wrap(body io.ReadCloser) io.ReadCloser {
+	return &trackingReader{ReadCloser: body, tracker: t}
+}
+
+// Finish records the outcome of the request. Failed responses (4xx/5xx)
+// mark the upload failed unless it was already cancelled.
+func (t *Tracker) Finish(responseStatus int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finishedAt = time.Now()
+	t.responseStatus = responseStatus
+	switch {
+	case t.status == StatusCancelled:
+	case responseStatus >= 400:
+		t.status = StatusFailed
+	default:
+		t.status = StatusCompleted
+	}
+}
+
+// Snapshot returns the current state of the upload
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := Snapshot{
+		ID:             t.upload.ID,
+		TenantID:       t.upload.TenantID,
+		Client:         t.upload.Client,
+		Path:           t.upload.Path,
+		Status:         t.status,
+		BytesReceived:  t.received.Load(),
+		ExpectedBytes:  t.upload.ExpectedBytes,
+		StartedAt:      t.startedAt,
+		LastActivityAt: time.Unix(0, t.lastActivity.Load()),
+		ResponseStatus: t.responseStatus,
+	}
+
+	end := time.Now()
+	if !t.finishedAt.IsZero() {
+		finishedAt := t.finishedAt
+		snapshot.FinishedAt = &finishedAt
+		end = finishedAt
+	}
+	if elapsed := end.Sub(t.startedAt).Seconds(); elapsed > 0 {
+		snapshot.BytesPerSecond = float64(snapshot.BytesReceived) / elapsed
+	}
+	if snapshot.ExpectedBytes > 0 {
+		percent := min(100*float64(snapshot.BytesReceived)/float64(snapshot.ExpectedBytes), 100)
+		snapshot.Percent = &percent
+	}
+	return snapshot
+}
+
+// setStatus moves the upload from one state to another, reporting whether
+// it was in the from state
+func (t *Tracker) setStatus(from, to string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status != from {
+		return false
+	}
+	t.status = to
+	return true
+}
+
+// abort cancels the upload if it is still receiving its body, making reads
+// fail with err
+func (t *Tracker) abort(err error) bool {
+	if !t.setStatus(StatusReceiving, StatusCancelled) {
+		return false
+	}
+	t.cancelErr.Store(&err)
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return true
+}
+
+type trackingReader struct {
+	io.ReadCloser
+	tracker *Tracker
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	t := r.tracker
+	if n > 0 {
+		t.received.Add(int64(n))
+		t.lastActivity.Store(time.Now().UnixNano())
+	}
+	if cancelErr := t.cancelErr.Load(); err != nil && cancelErr != nil {
+		return n, *cancelErr
+	}
+	if err == io.EOF {
+		t.setStatus(StatusReceiving, StatusProcessing)
+	}
+	return n, err
+}
+
+// key identifies an upload in the registry. IDs are chosen by clients, so
+// each tenant has its own.
+type key struct {
+	tenant string
+	id     string
+}
+
+// Registry holds the trackers of in-flight and recently finished uploads
+type Registry struct {
+	idleTimeout time.Duration
+	minRate     int64
+	rateWindow  time.Duration
+
+	mu       sync.Mutex
+	trackers map[key]*Tracker
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewRegistry creates a registry cancelling uploads idle for longer than
+// idleTimeout, or receiving less than minRate bytes per second over
+// rateWindow (0 disables either check); call Start to run the checks
+func NewRegistry(idleTimeout time.Duration, minRate int64, rateWindow time.Duration) *Registry {
+	return &Registry{
+		idleTimeout: idleTimeout,
+		minRate:     minRate,
+		rateWindow:  rateWindow,
+		trackers:    map[key]*Tracker{},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start checks for stalled and expired uploads in the background
+func (r *Registry) Start() {
+	go r.run()
+}
+
+// Stop stops the background checks
+func (r *Registry) Stop() {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+// Begin registers an upload. cancel is called when the upload stalls and
+// must make pending body reads fail. IDs are unique per tenant, and can be
+// reused once the previous upload under them has finished.
+func (r *Registry) Begin(upload Upload, cancel func()) (*Tracker, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key{tenant: upload.TenantID, id: upload.ID}
+	if existing, ok := r.trackers[k]; ok {
+		existing.mu.Lock()
+		finished := !existing.finishedAt.IsZero()
+		existing.mu.Unlock()
+		if !finished {
+			return nil, ErrInUse
+		}
+	}
+
+	now := time.Now()
+	tracker := &Tracker{
+		upload:      upload,
+		startedAt:   now,
+		cancel:      cancel,
+		status:      StatusReceiving,
+		windowStart: now,
+	}
+	tracker.lastActivity.Store(now.UnixNano())
+	r.trackers[k] = tracker
+	return tracker, nil
+}
+
+// Get returns the upload tenant registered under id
+func (r *Registry) Get(tenant, id string) (Snapshot, bool) {
+	r.mu.Lock()
+	tracker, ok := r.trackers[key{tenant: tenant, id: id}]
+	r.mu.Unlock()
+	if !ok {
+		return Snapshot{}, false
+	}
+	return tracker.Snapshot(), true
+}
+
+// List returns every registered upload, oldest first
+func (r *Registry) List() []Snapshot {
+	r.mu.Lock()
+	trackers := make([]*Tracker, 0, len(r.trackers))
+	for _, tracker := range r.trackers {
+		trackers = append(trackers, tracker)
+	}
+	r.mu.Unlock()
+
+	snapshots := make([]Snapshot, len(trackers))
+	for i, tracker := range trackers {
+		snapshots[i] = tracker.Snapshot()
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].StartedAt.Before(snapshots[j].StartedAt) })
+	return snapshots
+}
+
+// CancelAll cancels every upload still receiving its body, making reads fail
+// with ErrShutdown, and returns how many were cancelled
+func (r *Registry) CancelAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancelled := 0
+	for _, tracker := range r.trackers {
+		if tracker.abort(ErrShutdown) {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// Wait blocks until every registered upload has finished or ctx is done
+func (r *Registry) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+
+	for len(r.Active()) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Active counts the unfinished uploads by status
+func (r *Registry) Active() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, tracker := range r.trackers {
+		tracker.mu.Lock()
+		if tracker.finishedAt.IsZero() {
+			counts[tracker.status]++
+		}
+		tracker.mu.Unlock()
+	}
+	return counts
+}
+
+func (r *Registry) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.reap(time.Now())
+		}
+	}
+}
+
+// reap cancels stalled and slow uploads and forgets the ones finished long enough ago
+func (r *Registry) reap(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, tracker := range r.trackers {
+		tracker.mu.Lock()
+		finishedAt := tracker.finishedAt
+		tracker.mu.Unlock()
+
+		if !finishedAt.IsZero() {
+			if now.Sub(finishedAt) > finishedRetention {
+				delete(r.trackers, k)
+			}
+			continue
+		}
+
+		idle := now.Sub(time.Unix(0, tracker.lastActivity.Load()))
+		if r.idleTimeout > 0 && idle > r.idleTimeout {
+			tracker.abort(ErrStalled)
+			continue
+		}
+
+		// The rate is measured over consecutive windows, so a burst at the
+		// start can't carry a trickle for the rest of the upload
+		if elapsed := now.Sub(tracker.windowStart); r.minRate > 0 && r.rateWindow > 0 && elapsed >= r.rateWindow {
+			received := tracker.received.Load()
+			if float64(received-tracker.windowBytes)/elapsed.Seconds() < float64(r.minRate) {
+				tracker.abort(ErrTooSlow)
+				continue
+			}
+			tracker.windowStart, tracker.windowBytes = now, received
+		}
+	}
+}
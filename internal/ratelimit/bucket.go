@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"api-file-upload-go/internal/config"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate
+// requests per second
+type Limit struct {
+	Rate  float64
+	Burst float64
+}
+
+// LimitFrom converts a configured rate; Count requests per Period are
+// allowed in bursts of up to Count
+func LimitFrom(rate config.Rate) Limit {
+	if rate.Count <= 0 || rate.Period <= 0 {
+		return Limit{}
+	}
+	return Limit{Rate: float64(rate.Count) / rate.Period.Seconds(), Burst: float64(rate.Count)}
+}
+
+// Unlimited reports whether the limit lets everything through
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until a token is available, when refused
+	Reset      time.Duration // until the bucket is full again
+}
+
+// take refills a bucket holding tokens since updated and takes one token if
+// there is one, returning the tokens left, when the bucket will be full and
+// the result
+func take(tokens float64, updated, now time.Time, limit Limit) (float64, time.Time, Result) {
+	if elapsed := now.Sub(updated).Seconds(); elapsed > 0 {
+		tokens = math.Min(limit.Burst, tokens+elapsed*limit.Rate)
+	}
+
+	result := Result{Limit: int(limit.Burst)}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((limit.Burst - tokens) / limit.Rate)
+	return tokens, now.Add(result.Reset), result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
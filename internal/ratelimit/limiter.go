@@ -0,0 +1,252 @@
+// Package ratelimit limits how much each principal (API key, user or client
+// IP) can use the API: token buckets per request class, concurrent uploads
+// and a daily bandwidth quota. Buckets and usage live in memory, per
+// instance, or in Postgres to be shared by every instance. It also throttles
+// the bytes sent by downloads, per connection and per tenant and client IP.
+package ratelimit
+
+import (
+	"api-file-upload-go/internal/config"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Request classes, limited separately
+const (
+	ClassUpload   = "upload"
+	ClassDownload = "download"
+	ClassMetadata = "metadata"
+)
+
+// Stores selectable with RATE_LIMIT_STORE
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// sweepInterval is how often full buckets and old usage are forgotten
+const sweepInterval = time.Minute
+
+// settings are the limits a Limiter applies, replaced as a whole by
+// Configure
+type settings struct {
+	limits        map[string]Limit
+	maxConcurrent int
+	dailyQuota    int64
+
+	downloadRate       int64
+	tenantDownloadRate int64
+}
+
+// Limiter applies the configured limits
+type Limiter struct {
+	store    Store
+	settings atomic.Pointer[settings]
+	logger   *logrus.Logger
+
+	mu      sync.Mutex
+	active  map[string]int       // uploads running per principal, on this instance
+	tenants map[string]*Throttle // download throttles per tenant or client IP, on this instance
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New creates a limiter with the store selected by cfg; call Start to
+// sweep expired state in the background
+func New(cfg *config.Config, db *gorm.DB, logger *logrus.Logger) (*Limiter, error) {
+	var store Store
+	switch cfg.RateLimitStore {
+	case StoreMemory:
+		store = newMemoryStore()
+	case StorePostgres:
+		store = &postgresStore{db: db}
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q (use %s or %s)", cfg.RateLimitStore, StoreMemory, StorePostgres)
+	}
+
+	l := &Limiter{
+		store:   store,
+		logger:  logger,
+		active:  map[string]int{},
+		tenants: map[string]*Throttle{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	l.Configure(cfg)
+	return l, nil
+}
+
+// Configure applies the limits in cfg to requests from now on; the store
+// stays the one the limiter was created with. Buckets keep their tokens,
+// uploads already running keep counting against the concurrent upload cap
+// and downloads already running keep their throttles.
+func (l *Limiter) Configure(cfg *config.Config) {
+	s := &settings{
+		limits: map[string]Limit{
+			ClassUpload:   LimitFrom(cfg.RateLimitUploads),
+			ClassDownload: LimitFrom(cfg.RateLimitDownloads),
+			ClassMetadata: LimitFrom(cfg.RateLimitMetadata),
+		},
+		maxConcurrent:      cfg.MaxConcurrentUploads,
+		dailyQuota:         cfg.DailyBandwidthQuota,
+		downloadRate:       cfg.DownloadRate,
+		tenantDownloadRate: cfg.TenantDownloadRate,
+	}
+
+	// Tenant throttles are created again at the new rate
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.Store(s)
+	clear(l.tenants)
+}
+
+// Start sweeps full buckets and old usage in the background
+func (l *Limiter) Start() {
+	go l.run()
+}
+
+// Stop stops the background sweep
+func (l *Limiter) Stop() {
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+// Allow takes a token from the bucket of key for class. ok is false when
+// the class is unlimited, in which case there is no result to report.
+func (l *Limiter) Allow(ctx context.Context, key, class string) (result Result, ok bool, err error) {
+	limit := l.settings.Load().limits[class]
+	if limit.Unlimited() {
+		return Result{}, false, nil
+	}
+	result, err = l.store.Take(ctx, class+":"+key, limit, time.Now())
+	return result, err == nil, err
+}
+
+// AcquireUpload counts an upload by key against the concurrent upload
+// limit; ok is false when it is reached. release must be called once the
+// upload is done.
+func (l *Limiter) AcquireUpload(key string) (release func(), ok bool) {
+	maxConcurrent := l.settings.Load().maxConcurrent
+	if maxConcurrent <= 0 {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key] >= maxConcurrent {
+		return nil, false
+	}
+	l.active[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[key]--; l.active[key] <= 0 {
+				delete(l.active, key)
+			}
+		})
+	}, true
+}
+
+// MaxConcurrentUploads returns the concurrent upload limit (0 = unlimited)
+func (l *Limiter) MaxConcurrentUploads() int {
+	return l.settings.Load().maxConcurrent
+}
+
+// DailyQuota returns the daily bandwidth quota in bytes (0 = unlimited)
+func (l *Limiter) DailyQuota() int64 {
+	return l.settings.Load().dailyQuota
+}
+
+// QuotaLeft returns the bytes key may still transfer today and the time
+// until the quota resets at midnight UTC
+func (l *Limiter) QuotaLeft(ctx context.Context, key string) (int64, time.Duration, error) {
+	now := time.Now().UTC()
+	used, err := l.store.Usage(ctx, key, day(now))
+	if err != nil {
+		return 0, 0, err
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return max(l.settings.Load().dailyQuota-used, 0), midnight.Sub(now), nil
+}
+
+// RecordUsage adds bytes transferred by key today
+func (l *Limiter) RecordUsage(ctx context.Context, key string, bytes int64) error {
+	if bytes <= 0 {
+		return nil
+	}
+	return l.store.AddUsage(ctx, key, day(time.Now().UTC()), bytes)
+}
+
+// DownloadThrottles returns the throttles a download is read through: one of
+// its own, capped at DOWNLOAD_RATE_LIMIT, and for each of keys (a tenant, a
+// client IP) the one shared by its downloads, capped at
+// TENANT_DOWNLOAD_RATE_LIMIT. Caps that aren't set are nil.
+func (l *Limiter) DownloadThrottles(keys ...string) []*Throttle {
+	s := l.settings.Load()
+	throttles := []*Throttle{NewThrottle(s.downloadRate)}
+	if s.tenantDownloadRate > 0 {
+		l.mu.Lock()
+		for _, key := range keys {
+			shared, ok := l.tenants[key]
+			if !ok {
+				// Read again under the lock, which Configure holds while
+				// swapping the settings
+				if shared = NewThrottle(l.settings.Load().tenantDownloadRate); shared != nil {
+					l.tenants[key] = shared
+				}
+			}
+			throttles = append(throttles, shared)
+		}
+		l.mu.Unlock()
+	}
+	return throttles
+}
+
+func (l *Limiter) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			// Usage from yesterday is kept so requests running across
+			// midnight still find their day
+			now := time.Now().UTC()
+			if err := l.store.Sweep(context.Background(), now, day(now.AddDate(0, 0, -1))); err != nil {
+				l.logger.Warn("Failed to sweep rate limits:", err)
+			}
+			l.sweepThrottles(now)
+		}
+	}
+}
+
+// sweepThrottles forgets the shared throttles no download is using
+func (l *Limiter) sweepThrottles(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, throttle := range l.tenants {
+		if throttle.idle(now) {
+			delete(l.tenants, key)
+		}
+	}
+}
+
+// day formats t as the usage day
+func day(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"api-file-upload-go/internal/models"
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Store keeps the token buckets and daily bandwidth usage
+type Store interface {
+	// Take takes a token from the bucket under key
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// AddUsage adds bytes to the usage of key on day
+	AddUsage(ctx context.Context, key, day string, bytes int64) error
+	// Usage returns the bytes used by key on day
+	Usage(ctx context.Context, key, day string) (int64, error)
+	// Sweep forgets full buckets and usage from before day
+	Sweep(ctx context.Context, now time.Time, day string) error
+}
+
+// memoryStore keeps the limits of this instance only
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	usage   map[usageKey]int64
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	fullAt  time.Time
+}
+
+type usageKey struct {
+	key string
+	day string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets: map[string]*memoryBucket{},
+		usage:   map[usageKey]int64{},
+	}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: limit.Burst, updated: now}
+		s.buckets[key] = bucket
+	}
+	var result Result
+	bucket.tokens, bucket.fullAt, result = take(bucket.tokens, bucket.updated, now, limit)
+	bucket.updated = now
+	return result, nil
+}
+
+func (s *memoryStore) AddUsage(ctx context.Context, key, day string, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage[usageKey{key, day}] += bytes
+	return nil
+}
+
+func (s *memoryStore) Usage(ctx context.Context, key, day string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[usageKey{key, day}], nil
+}
+
+func (s *memoryStore) Sweep(ctx context.Context, now time.Time, day string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bucket := range s.buckets {
+		if !bucket.fullAt.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	for key := range s.usage {
+		if key.day < day {
+			delete(s.usage, key)
+		}
+	}
+	return nil
+}
+
+// postgresStore keeps the limits in the database, shared by every instance.
+// Each bucket row is locked while a token is taken.
+type postgresStore struct {
+	db *gorm.DB
+}
+
+func (s *postgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	var result Result
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fresh := models.RateLimitBucket{Key: key, Tokens: limit.Burst, UpdatedAt: now, FullAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&fresh).Error; err != nil {
+			return err
+		}
+
+		var bucket models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&bucket, "key = ?", key).Error; err != nil {
+			return err
+		}
+		var fullAt time.Time
+		bucket.Tokens, fullAt, result = take(bucket.Tokens, bucket.UpdatedAt, now, limit)
+		return tx.Model(&bucket).Updates(map[string]interface{}{
+			"tokens":     bucket.Tokens,
+			"updated_at": now,
+			"full_at":    fullAt,
+		}).Error
+	})
+	return result, err
+}
+
+func (s *postgresStore) AddUsage(ctx context.Context, key, day string, bytes int64) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"bytes": gorm.Expr("bandwidth_usage.bytes + ?", bytes)}),
+	}).Create(&models.BandwidthUsage{Key: key, Day: day, Bytes: bytes}).Error
+}
+
+func (s *postgresStore) Usage(ctx context.Context, key, day string) (int64, error) {
+	var usage models.BandwidthUsage
+	err := s.db.WithContext(ctx).Where("key = ? AND day = ?", key, day).Limit(1).Find(&usage).Error
+	return usage.Bytes, err
+}
+
+func (s *postgresStore) Sweep(ctx context.Context, now time.Time, day string) error {
+	db := s.db.WithContext(ctx)
+	if err := db.Where("full_at <= ?", now).Delete(&models.RateLimitBucket{}).Error; err != nil {
+		return err
+	}
+	return db.Where("day < ?", day).Delete(&models.BandwidthUsage{}).Error
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Throttle caps the rate of the bytes read through the readers sharing it
+type Throttle struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle creates a throttle passing bytesPerSecond, in bursts of up to
+// a second's worth. It returns nil, which readers ignore, when
+// bytesPerSecond isn't positive.
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &Throttle{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// reserve takes n bytes, going into debt when there aren't enough, and
+// returns how long to wait before they may pass
+func (t *Throttle) reserve(n int, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(now)
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return seconds(-t.tokens / t.rate)
+}
+
+// idle reports whether the throttle is back to a full burst, so forgetting
+// it changes nothing
+func (t *Throttle) idle(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refill(now)
+	return t.tokens >= t.burst
+}
+
+func (t *Throttle) refill(now time.Time) {
+	if elapsed := now.Sub(t.last).Seconds(); elapsed > 0 {
+		t.tokens = math.Min(t.burst, t.tokens+elapsed*t.rate)
+		t.last = now
+	}
+}
+
+// Reader throttles and counts the bytes read through it. Reads are cut to
+// the smallest burst, so one read never waits much longer than a second.
+type Reader struct {
+	ctx       context.Context
+	r         io.Reader
+	throttles []*Throttle
+	chunk     int
+	n         int64
+}
+
+// NewReader reads from r through throttles, skipping nil ones. A wait is cut
+// short with ctx's error when ctx ends.
+func NewReader(ctx context.Context, r io.Reader, throttles ...*Throttle) *Reader {
+	reader := &Reader{ctx: ctx, r: r}
+	for _, t := range throttles {
+		if t == nil {
+			continue
+		}
+		reader.throttles = append(reader.throttles, t)
+		if chunk := max(int(t.burst), 1); reader.chunk == 0 || chunk < reader.chunk {
+			reader.chunk = chunk
+		}
+	}
+	return reader
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.chunk > 0 && len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if n == 0 || len(r.throttles) == 0 {
+		return n, err
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, t := range r.throttles {
+		wait = max(wait, t.reserve(n, now))
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			return n, r.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// Seek seeks the underlying reader, which must be an io.Seeker
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("ratelimit: reader can't seek")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// N returns the bytes read so far
+func (r *Reader) N() int64 {
+	return r.n
+}
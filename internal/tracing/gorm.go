@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// parentContextKey stores the statement's context from before its span
+// started, restored once the statement is done
+const parentContextKey = "tracing:parent_context"
+
+// InstrumentGORM adds a span for every statement run with a context carrying
+// a span (db.WithContext), so queries show up under the request that ran
+// them. Statements without one, like migrations and background jobs, are
+// not traced.
+func InstrumentGORM(db *gorm.DB) error {
+	system := db.Dialector.Name()
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register("tracing:before_create", startQuerySpan(system, "create")),
+		callbacks.Create().After("*").Register("tracing:after_create", endQuerySpan),
+		callbacks.Query().Before("*").Register("tracing:before_query", startQuerySpan(system, "query")),
+		callbacks.Query().After("*").Register("tracing:after_query", endQuerySpan),
+		callbacks.Update().Before("*").Register("tracing:before_update", startQuerySpan(system, "update")),
+		callbacks.Update().After("*").Register("tracing:after_update", endQuerySpan),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", startQuerySpan(system, "delete")),
+		callbacks.Delete().After("*").Register("tracing:after_delete", endQuerySpan),
+		callbacks.Row().Before("*").Register("tracing:before_row", startQuerySpan(system, "row")),
+		callbacks.Row().After("*").Register("tracing:after_row", endQuerySpan),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", startQuerySpan(system, "raw")),
+		callbacks.Raw().After("*").Register("tracing:after_raw", endQuerySpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuerySpan(system, operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+
+		name := "db." + operation
+		if tx.Statement.Table != "" {
+			name += " " + tx.Statement.Table
+		}
+		spanCtx, _ := Start(ctx, name,
+			semconv.DBSystemKey.String(system),
+			semconv.DBOperationName(operation),
+		)
+		tx.InstanceSet(parentContextKey, ctx)
+		tx.Statement.Context = spanCtx
+	}
+}
+
+func endQuerySpan(tx *gorm.DB) {
+	parent, ok := tx.InstanceGet(parentContextKey)
+	if !ok {
+		return
+	}
+
+	span := trace.SpanFromContext(tx.Statement.Context)
+	if tx.Statement.Table != "" {
+		span.SetAttributes(semconv.DBCollectionName(tx.Statement.Table))
+	}
+	span.SetAttributes(
+		semconv.DBQueryText(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+
+	tx.Statement.Context = parent.(context.Context)
+}
@@ -0,0 +1,92 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned when a webhook URL resolves to an address
+// deliveries may not reach
+var ErrBlockedAddress = errors.New("webhook destination address is not allowed")
+
+// reservedNetworks are the special-purpose ranges not covered by the
+// netip.Addr predicates used in addressAllowed
+var reservedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// addressAllowed reports whether deliveries may connect to addr: public
+// addresses, and any address within allowed. Loopback, private, link-local
+// (which holds cloud metadata services) and reserved addresses are refused.
+func addressAllowed(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, network := range allowed {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNetworks turns IPs and CIDRs into prefixes; entries that don't parse
+// are skipped, as config.Load already rejects them
+func parseNetworks(list []string) []netip.Prefix {
+	var networks []netip.Prefix
+	for _, entry := range list {
+		if network, err := netip.ParsePrefix(entry); err == nil {
+			networks = append(networks, network.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return networks
+}
+
+// newTransport returns the transport deliveries are sent through. The
+// address is checked when connecting, after DNS resolution, so a host name
+// can't reach a refused address by resolving to it later. Proxies from the
+// environment aren't used, as the check would apply to the proxy instead.
+func newTransport(allowed []netip.Prefix) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !addressAllowed(addr, allowed) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
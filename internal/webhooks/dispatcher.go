@@ -0,0 +1,364 @@
+package webhooks
+
+import (
+	"api-file-upload-go/internal/config"
+	"api-file-upload-go/internal/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// pollInterval is how often the outbox and due retries are checked when
+	// nothing wakes the dispatcher earlier
+	pollInterval = 2 * time.Second
+	// batchSize bounds the events fanned out and deliveries claimed per round
+	batchSize = 100
+	// maxRetryDelay caps the exponential backoff between attempts
+	maxRetryDelay = 6 * time.Hour
+	// maxLoggedResponse bounds the response body kept in delivery logs
+	maxLoggedResponse = 1024
+)
+
+// Dispatcher fans outbox events out to the matching webhooks and delivers
+// them. Deliveries are claimed with a lease, so several API instances can run
+// a dispatcher against the same database.
+type Dispatcher struct {
+	db          *gorm.DB
+	logger      *logrus.Logger
+	client      *http.Client
+	maxAttempts int
+	retryDelay  time.Duration
+	workers     int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewDispatcher creates a dispatcher; call Start to begin delivering
+func NewDispatcher(cfg *config.Config, db *gorm.DB, logger *logrus.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		logger: logger,
+		client: &http.Client{
+			Timeout:   cfg.WebhookTimeout,
+			Transport: newTransport(parseNetworks(cfg.WebhookAllowedNets)),
+			// A redirect is reported as a failed delivery instead of
+			// sending the payload somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts: cfg.WebhookMaxAttempts,
+		retryDelay:  cfg.WebhookRetryDelay,
+		workers:     cfg.WebhookWorkers,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs the dispatcher in the background until Stop is called
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop stops the dispatcher and waits for in-flight deliveries to finish.
+// Unfinished work stays in the database and is picked up on the next start.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.stop) })
+	<-d.done
+}
+
+// Notify wakes the dispatcher after new events were committed or a delivery
+// was queued, instead of waiting for the next poll
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		if err := d.dispatchEvents(); err != nil {
+			d.logger.Error("Failed to dispatch webhook events:", err)
+		}
+		if err := d.deliverDue(); err != nil {
+			d.logger.Error("Failed to deliver webhooks:", err)
+		}
+	}
+}
+
+// lockRows makes a query claim its rows where the database supports it, so
+// concurrent dispatchers skip each other's work
+func (d *Dispatcher) lockRows(tx *gorm.DB) *gorm.DB {
+	if d.db.Dialector.Name() == "postgres" {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
+	return tx
+}
+
+// dispatchEvents creates a pending delivery for every webhook subscribed to
+// each undispatched outbox event
+func (d *Dispatcher) dispatchEvents() error {
+	for {
+		var dispatched int
+		err := d.db.Transaction(func(tx *gorm.DB) error {
+			var events []models.WebhookEvent
+			if err := d.lockRows(tx.Where("dispatched_at IS NULL").Order("id").Limit(batchSize)).Find(&events).Error; err != nil {
+				return err
+			}
+			dispatched = len(events)
+			if len(events) == 0 {
+				return nil
+			}
+
+			hooks := map[string][]models.Webhook{}
+			var deliveries []models.WebhookDelivery
+			ids := make([]uint, 0, len(events))
+			now := time.Now()
+			for _, event := range events {
+				ids = append(ids, event.ID)
+
+				tenantHooks, ok := hooks[event.TenantID]
+				if !ok {
+					if err := tx.Where("tenant_id = ? AND active = ?", event.TenantID, true).Find(&tenantHooks).Error; err != nil {
+						return err
+					}
+					hooks[event.TenantID] = tenantHooks
+				}
+				for _, hook := range tenantHooks {
+					if hook.Subscribes(event.Type) {
+						deliveries = append(deliveries, models.WebhookDelivery{
+							WebhookID:     hook.ID,
+							EventID:       event.ID,
+							Status:        models.DeliveryPending,
+							NextAttemptAt: now,
+						})
+					}
+				}
+			}
+
+			if len(deliveries) > 0 {
+				if err := tx.CreateInBatches(&deliveries, batchSize).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&models.WebhookEvent{}).Where("id IN ?", ids).Update("dispatched_at", now).Error
+		})
+		if err != nil || dispatched < batchSize {
+			return err
+		}
+	}
+}
+
+// deliverDue sends the pending deliveries whose next attempt is due
+func (d *Dispatcher) deliverDue() error {
+	for {
+		deliveries, err := d.claimDue()
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		sem := make(chan struct{}, d.workers)
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(delivery *models.WebhookDelivery) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				d.deliver(delivery)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		select {
+		case <-d.stop:
+			return nil
+		default:
+		}
+		if len(deliveries) < batchSize {
+			return nil
+		}
+	}
+}
+
+// claimDue selects due deliveries and pushes their next attempt past the
+// request timeout, so no other dispatcher picks them up meanwhile. A crashed
+// dispatcher's claims expire and are retried.
+func (d *Dispatcher) claimDue() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		query := tx.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").
+			Limit(batchSize)
+		if err := d.lockRows(query).Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		lease := now.Add(d.client.Timeout + time.Minute)
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).UpdateColumn("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// deliver makes one attempt at a delivery and records its outcome
+func (d *Dispatcher) deliver(delivery *models.WebhookDelivery) {
+	var hook models.Webhook
+	if err := d.db.First(&hook, delivery.WebhookID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			d.finish(delivery, models.DeliveryFailed, attemptResult{err: "Webhook was deleted"})
+			return
+		}
+		d.logger.Warn("Failed to load webhook:", err)
+		return
+	}
+	if !hook.Active {
+		d.finish(delivery, models.DeliveryFailed, attemptResult{err: "Webhook is disabled"})
+		return
+	}
+
+	var event models.WebhookEvent
+	if err := d.db.First(&event, delivery.EventID).Error; err != nil {
+		d.logger.Warn("Failed to load webhook event:", err)
+		return
+	}
+
+	result := d.send(&hook, &event, delivery)
+	delivery.Attempts++
+
+	switch {
+	case result.ok():
+		d.finish(delivery, models.DeliverySucceeded, result)
+	case delivery.Attempts >= d.maxAttempts:
+		d.logger.Warnf("Webhook delivery %d to %s failed permanently after %d attempts", delivery.ID, hook.URL, delivery.Attempts)
+		d.finish(delivery, models.DeliveryFailed, result)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		d.finish(delivery, models.DeliveryPending, result)
+	}
+}
+
+// attemptResult is the outcome of one HTTP attempt
+type attemptResult struct {
+	statusCode int
+	response   string
+	err        string
+	duration   time.Duration
+}
+
+func (r attemptResult) ok() bool {
+	return r.err == "" && r.statusCode >= 200 && r.statusCode < 300
+}
+
+// send POSTs the signed event payload to the webhook
+func (d *Dispatcher) send(hook *models.Webhook, event *models.WebhookEvent, delivery *models.WebhookDelivery) attemptResult {
+	body, err := json.Marshal(Payload{
+		ID:        event.ID,
+		Type:      event.Type,
+		TenantID:  event.TenantID,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Data,
+	})
+	if err != nil {
+		return attemptResult{err: err.Error()}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return attemptResult{err: err.Error()}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "api-file-upload-go-webhooks/1.0")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderEventID, strconv.FormatUint(uint64(event.ID), 10))
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return attemptResult{err: err.Error(), duration: time.Since(start)}
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedResponse))
+	result := attemptResult{
+		statusCode: resp.StatusCode,
+		response:   string(bytes.ToValidUTF8(bytes.ReplaceAll(response, []byte{0}, nil), nil)),
+		duration:   time.Since(start),
+	}
+	if !result.ok() {
+		result.err = fmt.Sprintf("Unexpected response status %d", resp.StatusCode)
+	}
+	return result
+}
+
+// finish stores the state of a delivery after an attempt
+func (d *Dispatcher) finish(delivery *models.WebhookDelivery, status string, result attemptResult) {
+	updates := map[string]interface{}{
+		"status":           status,
+		"attempts":         delivery.Attempts,
+		"next_attempt_at":  delivery.NextAttemptAt,
+		"last_status_code": result.statusCode,
+		"last_error":       result.err,
+		"last_response":    result.response,
+		"last_duration_ms": result.duration.Milliseconds(),
+	}
+	if status == models.DeliverySucceeded {
+		updates["delivered_at"] = time.Now()
+	}
+	if err := d.db.Model(delivery).Updates(updates).Error; err != nil {
+		d.logger.Warn("Failed to record webhook delivery:", err)
+	}
+}
+
+// backoff returns the wait before the next attempt: the retry delay doubled
+// for every failed attempt, capped at maxRetryDelay, with up to 20% jitter
+// so failing receivers aren't hit by every retry at once
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	return delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+}
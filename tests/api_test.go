@@ -1,11 +1,26 @@
 package tests
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -79,6 +94,732 @@ func TestListFiles(t *testing.T) {
 	}
 }
 
+func TestSearchFiles(t *testing.T) {
+	// Names made of a unique word each, so only the matching file can rank
+	run := time.Now().UnixNano()
+	wanted := fmt.Sprintf("searchwanted%d", run)
+	unrelated := fmt.Sprintf("searchother%d", run)
+	wantedID := uploadTestFile(t, "report-"+wanted+".txt", "search test content "+wanted)
+	unrelatedID := uploadTestFile(t, "report-"+unrelated+".txt", "search test content "+unrelated)
+
+	resp, err := http.Get("http://localhost:80/api/v1/search?q=" + wanted)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Results []struct {
+				File struct {
+					ID   uint   `json:"id"`
+					Name string `json:"name"`
+				} `json:"file"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	found := false
+	for _, result := range body.Data.Results {
+		switch result.File.ID {
+		case wantedID:
+			found = true
+		case unrelatedID:
+			t.Errorf("Unrelated file %s matched %q", result.File.Name, wanted)
+		}
+	}
+	if !found {
+		t.Errorf("Expected file %d in the results for %q, got %+v", wantedID, wanted, body.Data.Results)
+	}
+
+	resp, err = http.Get("http://localhost:80/api/v1/search")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a query, got %d", resp.StatusCode)
+	}
+}
+
+// uploadTestFile uploads content as name and returns the ID of the new file
+func uploadTestFile(t *testing.T, name, content string) uint {
+	t.Helper()
+	return uploadTestFileWith(t, name, content, nil)
+}
+
+// uploadTestFileWith uploads content as name along with the form fields
+// (folder_id, metadata) and returns the ID of the new file
+func uploadTestFileWith(t *testing.T, name, content string, fields map[string]string) uint {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for field, value := range fields {
+		writer.WriteField(field, value)
+	}
+	fileWriter, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write([]byte(content))
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "http://localhost:80/api/v1/files/upload", &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201 uploading %s, got %d", name, resp.StatusCode)
+	}
+	var body struct {
+		File struct {
+			ID uint `json:"id"`
+		} `json:"file"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return body.File.ID
+}
+
+// sendJSON sends body encoded as JSON with the given headers
+func sendJSON(t *testing.T, method, url string, body interface{}, headers map[string]string) *http.Response {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	return resp
+}
+
+func TestFolderTrashAndReupload(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	content := fmt.Sprintf("folder trash test content %d", suffix)
+
+	resp := sendJSON(t, "POST", "http://localhost:80/api/v1/folders", map[string]string{
+		"name": fmt.Sprintf("trash-test-%d", suffix),
+	}, nil)
+	var folder struct {
+		Data struct {
+			ID uint `json:"id"`
+		} `json:"data"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&folder)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || err != nil {
+		t.Fatalf("Expected status 201 creating a folder, got %d (%v)", resp.StatusCode, err)
+	}
+	folderURL := fmt.Sprintf("http://localhost:80/api/v1/folders/%d", folder.Data.ID)
+
+	fileID := uploadTestFileWith(t, "trashed.txt", content, map[string]string{
+		"folder_id": fmt.Sprint(folder.Data.ID),
+	})
+
+	// Deleting the folder moves the file to the trash with it
+	resp = sendJSON(t, "DELETE", folderURL, nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 deleting the folder, got %d", resp.StatusCode)
+	}
+
+	// Content in the trash doesn't block uploading it again
+	reuploadID := uploadTestFile(t, fmt.Sprintf("reuploaded-%d.txt", suffix), content)
+
+	// but the trashed copy can't come back while the new one is live
+	resp = sendJSON(t, "POST", folderURL+"/restore", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 restoring over live content, got %d", resp.StatusCode)
+	}
+
+	resp = sendJSON(t, "DELETE", fmt.Sprintf("http://localhost:80/api/v1/files/%d", reuploadID), nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 deleting the new upload, got %d", resp.StatusCode)
+	}
+
+	resp = sendJSON(t, "POST", folderURL+"/restore", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 restoring the folder, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:80/api/v1/files/%d", fileID))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the restored file to be found, got status %d", resp.StatusCode)
+	}
+}
+
+// replaceTestFileContent uploads content as a new version of file id
+func replaceTestFileContent(t *testing.T, id uint, name, content string) *http.Response {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write([]byte(content))
+	writer.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://localhost:80/api/v1/files/%d/content", id), &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	return resp
+}
+
+// downloadTestFile returns the body of a file download
+func downloadTestFile(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 downloading, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return string(body)
+}
+
+func TestFileVersions(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	first := fmt.Sprintf("version test content one %d", suffix)
+	second := fmt.Sprintf("version test content two %d", suffix)
+	fileID := uploadTestFile(t, fmt.Sprintf("versioned-%d.txt", suffix), first)
+	fileURL := fmt.Sprintf("http://localhost:80/api/v1/files/%d", fileID)
+
+	// A new version must keep the file's extension
+	resp := replaceTestFileContent(t, fileID, "versioned.md", second)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a version with another extension, got %d", resp.StatusCode)
+	}
+
+	resp = replaceTestFileContent(t, fileID, "versioned.txt", second)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 uploading a version, got %d", resp.StatusCode)
+	}
+	if got := downloadTestFile(t, fileURL+"/download"); got != second {
+		t.Errorf("Expected the new version to be downloaded, got %q", got)
+	}
+	if got := downloadTestFile(t, fileURL+"/download?version=1"); got != first {
+		t.Errorf("Expected version 1 to be downloaded, got %q", got)
+	}
+
+	resp, err := http.Get(fileURL + "/versions")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	var versions struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+			Versions       []struct {
+				Version int  `json:"version"`
+				Current bool `json:"current"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&versions)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if versions.Data.CurrentVersion != 2 || len(versions.Data.Versions) != 2 {
+		t.Errorf("Expected 2 versions with version 2 current, got %+v", versions.Data)
+	}
+
+	// Promoting with a stale ETag is refused
+	resp = sendJSON(t, "POST", fileURL+"/versions/1/promote", nil, map[string]string{
+		"If-Match": fmt.Sprintf(`"%d-0"`, fileID),
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 promoting with a stale ETag, got %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected the current ETag with 412")
+	}
+
+	resp = sendJSON(t, "POST", fileURL+"/versions/1/promote", nil, map[string]string{"If-Match": etag})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 promoting version 1, got %d", resp.StatusCode)
+	}
+	if got := downloadTestFile(t, fileURL+"/download"); got != first {
+		t.Errorf("Expected the promoted version to be downloaded, got %q", got)
+	}
+}
+
+func TestUpdateFile(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	fileID := uploadTestFile(t, fmt.Sprintf("update-%d.txt", suffix), fmt.Sprintf("update test content %d", suffix))
+	fileURL := fmt.Sprintf("http://localhost:80/api/v1/files/%d", fileID)
+
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag on the file")
+	}
+
+	newName := fmt.Sprintf("renamed-%d.txt", suffix)
+	resp = sendJSON(t, "PATCH", fileURL, map[string]string{"name": newName}, map[string]string{"If-Match": etag})
+	var result struct {
+		Data struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("Expected status 200 renaming, got %d (%v)", resp.StatusCode, err)
+	}
+	if result.Data.Name != newName {
+		t.Errorf("Expected name %s, got %s", newName, result.Data.Name)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("Expected the ETag to change with the update")
+	}
+
+	// The ETag read before the rename is now stale
+	resp = sendJSON(t, "PATCH", fileURL, map[string]string{"name": "stale.txt"}, map[string]string{"If-Match": etag})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 with a stale ETag, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("Expected the current ETag with 412")
+	}
+}
+
+func TestBatchAtomicRollback(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	fileID := uploadTestFile(t, fmt.Sprintf("batch-%d.txt", suffix), fmt.Sprintf("batch test content %d", suffix))
+	tag := fmt.Sprintf("batch-%d", suffix)
+
+	type batchResult struct {
+		Data struct {
+			Results []struct {
+				Success bool   `json:"success"`
+				Status  int    `json:"status"`
+				Message string `json:"message"`
+			} `json:"results"`
+			Summary struct {
+				Succeeded int `json:"succeeded"`
+				Failed    int `json:"failed"`
+			} `json:"summary"`
+		} `json:"data"`
+	}
+	runBatch := func(atomic bool) batchResult {
+		resp := sendJSON(t, "POST", "http://localhost:80/api/v1/files/batch", map[string]interface{}{
+			"atomic": atomic,
+			"operations": []map[string]interface{}{
+				{"op": "tag", "id": fileID, "add": []string{tag}},
+				{"op": "get", "id": 4294967295},
+			},
+		}, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var result batchResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(result.Data.Results) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(result.Data.Results))
+		}
+		return result
+	}
+	fileTags := func() []string {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:80/api/v1/files/%d", fileID))
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+		var file struct {
+			Data struct {
+				Tags []string `json:"tags"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return file.Data.Tags
+	}
+
+	// One failing operation rolls back the whole atomic batch
+	result := runBatch(true)
+	if first := result.Data.Results[0]; first.Success || first.Status != http.StatusConflict || first.Message != "Rolled back" {
+		t.Errorf("Expected the tag operation to be rolled back, got %+v", first)
+	}
+	if second := result.Data.Results[1]; second.Success || second.Status != http.StatusNotFound {
+		t.Errorf("Expected the missing file to fail with 404, got %+v", second)
+	}
+	if result.Data.Summary.Succeeded != 0 || result.Data.Summary.Failed != 2 {
+		t.Errorf("Expected 0 succeeded and 2 failed, got %+v", result.Data.Summary)
+	}
+	for _, got := range fileTags() {
+		if got == tag {
+			t.Errorf("Expected tag %s to be rolled back", tag)
+		}
+	}
+
+	// Without atomic, the operations that succeed are kept
+	result = runBatch(false)
+	if !result.Data.Results[0].Success || result.Data.Summary.Succeeded != 1 {
+		t.Errorf("Expected the tag operation to succeed, got %+v", result.Data)
+	}
+	found := false
+	for _, got := range fileTags() {
+		found = found || got == tag
+	}
+	if !found {
+		t.Errorf("Expected tag %s on the file", tag)
+	}
+}
+
+// postTestUpload posts content as the file part name to url
+func postTestUpload(t *testing.T, url, name string, content []byte) *http.Response {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write(content)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", url, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	return resp
+}
+
+// zipTestArchive builds a ZIP holding entries, by name
+func zipTestArchive(t *testing.T, entries map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create archive entry: %v", err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadExtractArchive(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	dir := fmt.Sprintf("extract-%d", suffix)
+	archive := zipTestArchive(t, map[string][]byte{
+		dir + "/inside.txt":  []byte(fmt.Sprintf("extracted content %d", suffix)),
+		dir + "/../../x.txt": []byte(fmt.Sprintf("escaping content %d", suffix)),
+	})
+
+	resp := postTestUpload(t, "http://localhost:80/api/v1/files/upload?extract=true", "archive.zip", archive)
+	var result struct {
+		Data struct {
+			Results []struct {
+				Entry   string `json:"entry"`
+				Success bool   `json:"success"`
+				Status  int    `json:"status"`
+				Message string `json:"message"`
+				File    struct {
+					VirtualPath string `json:"virtual_path"`
+				} `json:"file"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 with a failed entry, got %d", resp.StatusCode)
+	}
+	if len(result.Data.Results) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(result.Data.Results))
+	}
+	for _, entry := range result.Data.Results {
+		switch entry.Entry {
+		case dir + "/inside.txt":
+			if !entry.Success || entry.File.VirtualPath != dir+"/inside.txt" {
+				t.Errorf("Expected %s to be extracted into its folder, got %+v", entry.Entry, entry)
+			}
+		default:
+			// The entry escaping the archive's root is refused on its own
+			if entry.Success || entry.Status != http.StatusBadRequest || !strings.HasPrefix(entry.Message, "Unsafe path in archive") {
+				t.Errorf("Expected %s to be refused as unsafe, got %+v", entry.Entry, entry)
+			}
+		}
+	}
+
+	// An entry expanding far beyond the archive's size fails the whole archive
+	bomb := zipTestArchive(t, map[string][]byte{
+		fmt.Sprintf("zeros-%d.txt", suffix): make([]byte, 4<<20),
+	})
+	resp = postTestUpload(t, "http://localhost:80/api/v1/files/upload?extract=true", "bomb.zip", bomb)
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(result.Data.Results) != 1 {
+		t.Fatalf("Expected the archive to be reported as one failed item, got %d", len(result.Data.Results))
+	}
+	if item := result.Data.Results[0]; item.Success || item.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the archive to be refused with 413, got %+v", item)
+	}
+}
+
+func TestUploadStripImageMetadata(t *testing.T) {
+	// Pixels of their own, as the stripped photo must not be a duplicate
+	suffix := time.Now().UnixNano()
+	pixels := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	sum := sha256.Sum256([]byte(fmt.Sprint(suffix)))
+	for i := range pixels.Pix {
+		pixels.Pix[i] = sum[i%len(sum)]
+	}
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, pixels, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+
+	// An APP1 segment right after SOI with an empty big-endian EXIF block,
+	// followed by a marker to look for in the stored file
+	secret := fmt.Sprintf("camera-serial-%d", suffix)
+	exif := append([]byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"), secret...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+	photo := append(append(append([]byte{}, img.Bytes()[:2]...), segment...), img.Bytes()[2:]...)
+
+	resp := postTestUpload(t, "http://localhost:80/api/v1/files/upload?strip_metadata=true", fmt.Sprintf("photo-%d.jpg", suffix), photo)
+	var result struct {
+		File struct {
+			ID   uint  `json:"id"`
+			Size int64 `json:"size"`
+		} `json:"file"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || err != nil {
+		t.Fatalf("Expected status 201 uploading the photo, got %d (%v)", resp.StatusCode, err)
+	}
+
+	stored := downloadTestFile(t, fmt.Sprintf("http://localhost:80/api/v1/files/%d/download", result.File.ID))
+	if strings.Contains(stored, "Exif") || strings.Contains(stored, secret) {
+		t.Error("Expected the EXIF segment to be stripped")
+	}
+	if !strings.HasPrefix(stored, "\xff\xd8") || int64(len(stored)) != result.File.Size {
+		t.Errorf("Expected a %d byte JPEG, got %d bytes", result.File.Size, len(stored))
+	}
+	if _, err := jpeg.Decode(strings.NewReader(stored)); err != nil {
+		t.Errorf("Expected the stripped photo to decode: %v", err)
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	name := fmt.Sprintf("archived-%d.txt", suffix)
+
+	resp := sendJSON(t, "POST", "http://localhost:80/api/v1/folders", map[string]string{
+		"name": fmt.Sprintf("archive-test-%d", suffix),
+	}, nil)
+	var folder struct {
+		Data struct {
+			ID uint `json:"id"`
+		} `json:"data"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&folder)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || err != nil {
+		t.Fatalf("Expected status 201 creating a folder, got %d (%v)", resp.StatusCode, err)
+	}
+
+	// Two files with the same name, in different folders
+	contents := map[uint]string{}
+	rootID := uploadTestFile(t, name, fmt.Sprintf("archived at the root %d", suffix))
+	contents[rootID] = fmt.Sprintf("archived at the root %d", suffix)
+	folderID := uploadTestFileWith(t, name, fmt.Sprintf("archived in a folder %d", suffix), map[string]string{
+		"folder_id": fmt.Sprint(folder.Data.ID),
+	})
+	contents[folderID] = fmt.Sprintf("archived in a folder %d", suffix)
+
+	archive := downloadTestFile(t, fmt.Sprintf("http://localhost:80/api/v1/files/archive?ids=%d,%d", rootID, folderID))
+	zr, err := zip.NewReader(strings.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(content)
+	}
+
+	duplicate := strings.TrimSuffix(name, ".txt") + " (1).txt"
+	if len(entries) != 3 || entries[name] == "" || entries[duplicate] == "" || entries["manifest.json"] == "" {
+		t.Fatalf("Expected %s, %s and manifest.json, got %d entries", name, duplicate, len(entries))
+	}
+
+	var manifest struct {
+		Files []struct {
+			ID    uint   `json:"id"`
+			Name  string `json:"name"`
+			Error string `json:"error"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(entries["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("Expected 2 files in the manifest, got %d", len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		if file.Error != "" || entries[file.Name] != contents[file.ID] {
+			t.Errorf("Expected entry %s to hold file %d, got %q (%s)", file.Name, file.ID, entries[file.Name], file.Error)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// A 64x32 image with pixels of its own, so it isn't a duplicate
+	suffix := time.Now().UnixNano()
+	pixels := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	sum := sha256.Sum256([]byte(fmt.Sprint(suffix)))
+	for i := range pixels.Pix {
+		pixels.Pix[i] = sum[i%len(sum)]
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, pixels); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+	fileID := uploadTestFile(t, fmt.Sprintf("thumbnail-%d.png", suffix), img.String())
+	thumbnailURL := fmt.Sprintf("http://localhost:80/api/v1/files/%d/thumbnail?w=16&h=16", fileID)
+
+	resp, err := http.Get(thumbnailURL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	thumb, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatalf("Expected a PNG thumbnail: %v", err)
+	}
+	// contain keeps the aspect ratio within the box
+	if size := thumb.Bounds().Size(); size.X != 16 || size.Y != 8 {
+		t.Errorf("Expected a 16x8 thumbnail, got %dx%d", size.X, size.Y)
+	}
+
+	// The cached rendition is revalidated with its ETag
+	req, err := http.NewRequest("GET", thumbnailURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	cachedResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	cachedResp.Body.Close()
+	if cachedResp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status 304 with the ETag, got %d", cachedResp.StatusCode)
+	}
+
+	// Files other than images have no thumbnail
+	textID := uploadTestFile(t, fmt.Sprintf("thumbnail-%d.txt", suffix), fmt.Sprintf("thumbnail test content %d", suffix))
+	textResp, err := http.Get(fmt.Sprintf("http://localhost:80/api/v1/files/%d/thumbnail", textID))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	textResp.Body.Close()
+	if textResp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for a text file, got %d", textResp.StatusCode)
+	}
+}
+
 func TestStats(t *testing.T) {
 	resp, err := http.Get("http://localhost:80/api/v1/stats")
 	if err != nil {
@@ -90,3 +831,567 @@ func TestStats(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 }
+
+func TestUploadMultipleFiles(t *testing.T) {
+	// Prepare multipart form with two files, named and filled uniquely per
+	// run so neither the name nor the content rules refuse them
+	run := time.Now().UnixNano()
+	names := []string{fmt.Sprintf("multi-0-%d.txt", run), fmt.Sprintf("multi-1-%d.txt", run)}
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for i, name := range names {
+		fileWriter, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		if _, err := fileWriter.Write([]byte(fmt.Sprintf("multi upload file %d of run %d", i, run))); err != nil {
+			t.Fatalf("Failed to write file content: %v", err)
+		}
+	}
+
+	writer.Close()
+
+	// Make request
+	req, err := http.NewRequest("POST", "http://localhost:80/api/v1/files/upload", &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Results []struct {
+				Name    string `json:"name"`
+				Success bool   `json:"success"`
+				Message string `json:"message"`
+				File    *struct {
+					ID   uint   `json:"id"`
+					Name string `json:"name"`
+				} `json:"file"`
+			} `json:"results"`
+			Summary struct {
+				Succeeded int `json:"succeeded"`
+				Failed    int `json:"failed"`
+			} `json:"summary"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if body.Data.Summary.Succeeded != 2 || body.Data.Summary.Failed != 0 {
+		t.Errorf("Expected 2 files created and none failed, got %+v", body.Data.Summary)
+	}
+	if len(body.Data.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(body.Data.Results))
+	}
+	for i, result := range body.Data.Results {
+		if !result.Success || result.File == nil {
+			t.Errorf("Expected %s to be created, got %q", names[i], result.Message)
+			continue
+		}
+		if result.File.ID == 0 || result.File.Name != names[i] {
+			t.Errorf("Expected file %s to be created, got %+v", names[i], *result.File)
+		}
+	}
+}
+
+// TestWebhookDelivery needs the server to allow deliveries to the local
+// receiver, e.g. WEBHOOK_ALLOWED_NETWORKS=127.0.0.0/8
+func TestWebhookDelivery(t *testing.T) {
+	// Local receiver passing every delivery to the test, which checks the
+	// signature once it knows the secret
+	type delivery struct {
+		event     string
+		timestamp string
+		signature string
+		body      []byte
+	}
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{
+			event:     r.Header.Get("X-Webhook-Event"),
+			timestamp: r.Header.Get("X-Webhook-Timestamp"),
+			signature: r.Header.Get("X-Webhook-Signature"),
+			body:      body,
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	tenant := fmt.Sprintf("webhook-test-%d", time.Now().UnixNano())
+
+	// Subscribe the receiver to uploads
+	payload, _ := json.Marshal(map[string]interface{}{
+		"url":    receiver.URL,
+		"events": []string{"file.uploaded"},
+	})
+	req, err := http.NewRequest("POST", "http://localhost:80/api/v1/webhooks", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant-ID", tenant)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	var created struct {
+		Data struct {
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	secret := created.Data.Secret
+
+	// Upload a file for the same tenant
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, err := writer.CreateFormFile("file", tenant+".txt")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write([]byte("webhook test content " + tenant))
+	writer.Close()
+
+	req, err = http.NewRequest("POST", "http://localhost:80/api/v1/files/upload", &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Tenant-ID", tenant)
+
+	uploadResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer uploadResp.Body.Close()
+
+	if uploadResp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", uploadResp.StatusCode)
+	}
+
+	select {
+	case got := <-received:
+		if got.event != "file.uploaded" {
+			t.Errorf("Expected file.uploaded event, got %q", got.event)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(got.timestamp + "."))
+		mac.Write(got.body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(got.signature)) {
+			t.Errorf("Invalid webhook signature: %s", got.signature)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("Webhook was not delivered")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	tenant := fmt.Sprintf("events-test-%d", time.Now().UnixNano())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://localhost:80/api/v1/events?types=file.uploaded", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Tenant-ID", tenant)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Fatalf("Expected text/event-stream, got %q", contentType)
+	}
+
+	// Upload a file for the same tenant
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, err := writer.CreateFormFile("file", tenant+".txt")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write([]byte("event stream test content " + tenant))
+	writer.Close()
+
+	uploadReq, err := http.NewRequest("POST", "http://localhost:80/api/v1/files/upload", &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	uploadReq.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadReq.Header.Set("X-Tenant-ID", tenant)
+
+	uploadResp, err := http.DefaultClient.Do(uploadReq)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	uploadResp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == "event: file.uploaded" {
+			return
+		}
+	}
+	t.Fatalf("Stream ended without a file.uploaded event: %v", scanner.Err())
+}
+
+func TestUploadProgress(t *testing.T) {
+	uploadID := fmt.Sprintf("progress-test-%d", time.Now().UnixNano())
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fileWriter, err := writer.CreateFormFile("file", uploadID+".txt")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	fileWriter.Write([]byte("upload progress test content " + uploadID))
+	writer.Close()
+	size := buf.Len()
+
+	req, err := http.NewRequest("POST", "http://localhost:80/api/v1/files/upload", &buf)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Upload-ID", uploadID)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Upload-ID"); got != uploadID {
+		t.Errorf("Expected X-Upload-ID %q, got %q", uploadID, got)
+	}
+
+	progressResp, err := http.Get("http://localhost:80/api/v1/uploads/" + uploadID + "/progress")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer progressResp.Body.Close()
+
+	if progressResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", progressResp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			Status        string `json:"status"`
+			BytesReceived int64  `json:"bytes_received"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(progressResp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Data.Status != "completed" {
+		t.Errorf("Expected status completed, got %q", result.Data.Status)
+	}
+	if result.Data.BytesReceived != int64(size) {
+		t.Errorf("Expected %d bytes received, got %d", size, result.Data.BytesReceived)
+	}
+
+	// Upload IDs are per tenant, so another tenant doesn't see this one
+	req, err = http.NewRequest("GET", "http://localhost:80/api/v1/uploads/"+uploadID+"/progress", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Tenant-ID", "progress-test-other")
+	otherResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	otherResp.Body.Close()
+	if otherResp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another tenant, got %d", otherResp.StatusCode)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// Make sure at least one templated route has been requested
+	listResp, err := http.Get("http://localhost:80/api/v1/files")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	listResp.Body.Close()
+
+	resp, err := http.Get("http://localhost:80/metrics")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.Contains(string(body), `fileapi_http_requests_total{method="GET",route="/api/v1/files",status="200"}`) {
+		t.Errorf("Expected request counter for /api/v1/files in metrics output")
+	}
+	if !strings.Contains(string(body), "go_sql_open_connections") {
+		t.Errorf("Expected database pool metrics in output")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:80/api/v1/files", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Request-ID", "request-id-test-123")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get("X-Request-ID"); got != "request-id-test-123" {
+		t.Errorf("Expected X-Request-ID to be echoed, got %q", got)
+	}
+
+	// Without one, an ID is generated
+	resp, err = http.Get("http://localhost:80/api/v1/files")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("Expected a generated X-Request-ID")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	for _, path := range []string{"/livez", "/readyz"} {
+		resp, err := http.Get("http://localhost:80" + path)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get("http://localhost:80/health")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status string `json:"status"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	for _, name := range []string{"database", "storage", "migrations"} {
+		if result.Checks[name].Status != "up" {
+			t.Errorf("Expected %s check to be up, got %q", name, result.Checks[name].Status)
+		}
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	// Non-upload requests are limited to MAX_BODY_SIZE (1 MiB by default)
+	body := `{"name":"` + strings.Repeat("a", 2<<20) + `"}`
+	resp, err := http.Post("http://localhost:80/api/v1/folders", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", resp.StatusCode)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:80/api/v1/files", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-User-ID", "rate-limit-test")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("RateLimit-Limit") == "" || resp.Header.Get("RateLimit-Remaining") == "" {
+		t.Error("Expected RateLimit-Limit and RateLimit-Remaining headers")
+	}
+
+	// Probes aren't limited
+	resp, err = http.Get("http://localhost:80/livez")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("RateLimit-Limit") != "" {
+		t.Error("Expected no RateLimit-Limit header on /livez")
+	}
+}
+
+func TestEgressStats(t *testing.T) {
+	// A tenant of its own, so its egress is only this download
+	tenant := fmt.Sprintf("egress-test-%d", time.Now().UnixNano())
+	content := "egress test content " + tenant
+	fileID := uploadTestFile(t, tenant+".txt", content)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:80/api/v1/files/%d/download", fileID), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Tenant-ID", tenant)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 downloading, got %d", resp.StatusCode)
+	}
+
+	type fileEgress struct {
+		FileID uint  `json:"file_id"`
+		Bytes  int64 `json:"bytes"`
+	}
+	var egress *struct {
+		Tenant   string       `json:"tenant"`
+		Bytes    int64        `json:"bytes"`
+		TopFiles []fileEgress `json:"top_files"`
+	}
+	// The download is accounted once it has been sent, so allow a moment
+	for attempt := 0; attempt < 20; attempt++ {
+		req, err := http.NewRequest("GET", "http://localhost:80/api/v1/stats", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("X-Tenant-ID", tenant)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		var result struct {
+			Data struct {
+				Egress *struct {
+					Tenant   string       `json:"tenant"`
+					Bytes    int64        `json:"bytes"`
+					TopFiles []fileEgress `json:"top_files"`
+				} `json:"egress"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		egress = result.Data.Egress
+		if egress == nil {
+			t.Fatal("Expected egress in stats")
+		}
+		if egress.Bytes > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if egress.Tenant != tenant {
+		t.Errorf("Expected egress of tenant %s, got %s", tenant, egress.Tenant)
+	}
+	if egress.Bytes != int64(len(content)) {
+		t.Errorf("Expected %d bytes of egress, got %d", len(content), egress.Bytes)
+	}
+	if len(egress.TopFiles) != 1 || egress.TopFiles[0].FileID != fileID || egress.TopFiles[0].Bytes != int64(len(content)) {
+		t.Errorf("Expected file %d with %d bytes in top_files, got %+v", fileID, len(content), egress.TopFiles)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	req, err := http.NewRequest("OPTIONS", "http://localhost:80/api/v1/files/1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PATCH")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Errorf("Expected PATCH to be allowed, got %q", resp.Header.Get("Access-Control-Allow-Methods"))
+	}
+
+	// Actual requests expose the download headers
+	req, err = http.NewRequest("GET", "http://localhost:80/api/v1/files", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Origin", "https://app.example.com")
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Access-Control-Allow-Origin") == "" {
+		t.Error("Expected an Access-Control-Allow-Origin header")
+	}
+	if !strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "Content-Disposition") {
+		t.Errorf("Expected Content-Disposition to be exposed, got %q", resp.Header.Get("Access-Control-Expose-Headers"))
+	}
+}